@@ -12,14 +12,31 @@ import (
 )
 
 func main() {
-	var clear_version int
-	flag.IntVar(&clear_version, "clear_version", -1, "Clear Linux version")
+	var version_spec string
+	flag.StringVar(&version_spec, "clear_version", "installed",
+		common.VersionHelp)
 
 	var base_url string
 	flag.StringVar(&base_url, "url",
 		"https://github.com/clearlinux/clr-bundles",
 		"Base URL for downloading release archives of clr-bundles")
 
+	var base_repo_url string
+	flag.StringVar(&base_repo_url, "repo_url",
+		"https://cdn.download.clearlinux.org",
+		"Base URL downloading releases")
+
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var arch string
+	flag.StringVar(&arch, "arch", "",
+		"Architecture of the binary packages, overriding the layout")
+
+	var swupd_cert string
+	flag.StringVar(&swupd_cert, "swupd_cert", "",
+		"Only trust bundle metadata signed with this certificate")
+
 	flag.Usage = func() {
 		fmt.Printf("USAGE for %s\n", os.Args[0])
 		flag.PrintDefaults()
@@ -40,18 +57,27 @@ func main() {
 		}
 	}
 
-	if clear_version == -1 {
-		clear_version, err = common.GetInstalledVersion()
-		if err != nil {
-			fmt.Println("A version must be specified when not " +
-				"running on a Clear Linux instance!")
-			os.Exit(-1)
-		}
+	repo_layout, err := common.LoadLayout(repo_layout_path, base_repo_url, arch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	clear_version, err := common.ResolveVersion(version_spec, repo_layout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	trust, err := repolib.LoadTrust("", swupd_cert)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	files := make(map[string]bool)
 	for _, target_bundle := range args {
-		b, err := repolib.GetBundle(clear_version, target_bundle)
+		b, err := repolib.GetBundle(clear_version, target_bundle,
+			repo_layout, trust)
 		if err != nil {
 			log.Fatal(err)
 		}
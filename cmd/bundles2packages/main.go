@@ -8,18 +8,40 @@ import (
 	"github.com/intel/clear-linux-dissector/internal/repolib"
 	"log"
 	"os"
+	"sort"
 	"strings"
 )
 
 func main() {
-	var clear_version int
-	flag.IntVar(&clear_version, "clear_version", -1, "Clear Linux version")
+	var version_spec string
+	flag.StringVar(&version_spec, "clear_version", "installed",
+		common.VersionHelp)
 
 	var base_url string
 	flag.StringVar(&base_url, "url",
 		"https://github.com/clearlinux/clr-bundles",
 		"Base URL for downloading release archives of clr-bundles")
 
+	var base_repo_url string
+	flag.StringVar(&base_repo_url, "repo_url",
+		"https://cdn.download.clearlinux.org",
+		"Base URL downloading releases")
+
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var arch string
+	flag.StringVar(&arch, "arch", "",
+		"Architecture of the binary packages, overriding the layout")
+
+	var repo_keyring string
+	flag.StringVar(&repo_keyring, "repo_keyring", "",
+		"Only trust repo metadata signed by a key in this keyring")
+
+	var swupd_cert string
+	flag.StringVar(&swupd_cert, "swupd_cert", "",
+		"Only trust bundle metadata signed with this certificate")
+
 	flag.Usage = func() {
 		fmt.Printf("USAGE for %s\n", os.Args[0])
 		flag.PrintDefaults()
@@ -40,18 +62,36 @@ func main() {
 		}
 	}
 
-	if clear_version == -1 {
-		clear_version, err = common.GetInstalledVersion()
+	repo_layout, err := common.LoadLayout(repo_layout_path, base_repo_url, arch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	clear_version, err := common.ResolveVersion(version_spec, repo_layout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	trust, err := repolib.LoadTrust(repo_keyring, swupd_cert)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The package database was downloaded by another command, make sure
+	// it can still be authenticated
+	if trust != nil && trust.Keyring != nil {
+		err = repolib.DownloadRepo(clear_version, repo_layout, trust)
 		if err != nil {
-			fmt.Println("A version must be specified when not " +
-				"running on a Clear Linux instance!")
-			os.Exit(-1)
+			log.Fatal(err)
 		}
 	}
 
 	requirements := make(map[string]bool)
 	for _, target_bundle := range args {
-		b, err := repolib.GetBundle(clear_version, target_bundle)
+		b, err := repolib.GetBundle(clear_version, target_bundle,
+			repo_layout, trust)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -65,7 +105,12 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	for _, p := range pkgs {
+	var names []string
+	for p := range pkgs {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	for _, p := range names {
 		fmt.Println(p)
 	}
 }
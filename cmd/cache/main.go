@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+func usage() {
+	fmt.Printf("USAGE for %s [options] list|verify|prune|gc [versions]\n",
+		os.Args[0])
+	fmt.Println("  list    show the cached versions with sizes and completeness")
+	fmt.Println("  verify  check cached source rpms and trees against the repo")
+	fmt.Println("  prune   remove versions beyond -keep or older than -days")
+	fmt.Println("  gc      remove interrupted downloads and extractions")
+	flag.PrintDefaults()
+}
+
+func versionArgs(args []string) []int {
+	if len(args) == 0 {
+		versions, err := repolib.CachedVersions()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return versions
+	}
+
+	var versions []int
+	for _, a := range args {
+		v, err := strconv.Atoi(a)
+		if err != nil {
+			fmt.Printf("Invalid version %s\n", a)
+			os.Exit(-1)
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+func remove(path string, dry_run bool) {
+	if dry_run {
+		fmt.Printf("Would remove %s\n", path)
+		return
+	}
+	err := repolib.RemoveCached(path)
+	if err == downloader.ErrLocked {
+		fmt.Printf("Skipping %s, in use by another process\n", path)
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Removing %s\n", path)
+}
+
+func list(versions []int) {
+	for _, v := range versions {
+		c, err := repolib.StatCachedVersion(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		state := "incomplete"
+		if c.SrpmsDone {
+			state = "complete"
+		}
+		fmt.Printf("%d\t%s\tlast used %s\n", c.Version,
+			humanize.Bytes(uint64(c.Size)), c.LastUsed.Format("2006-01-02"))
+		fmt.Printf("\trepo: %t, source repo: %t, bundles: %t\n",
+			c.Repo, c.SrpmRepo, c.Bundles)
+		fmt.Printf("\tsrpms: %d (%s), sources: %d extracted, %d incomplete\n",
+			c.Srpms, state, c.Sources, c.Incomplete)
+	}
+}
+
+func verify(versions []int) {
+	failed := 0
+	for _, v := range versions {
+		failures, err := repolib.VerifyCache(v)
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			failed++
+			continue
+		}
+		repolib.ReportVerifyFailures(failures)
+		failed += len(failures)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d problems found in the cache!\n", failed)
+		os.Exit(-1)
+	}
+}
+
+func main() {
+	var keep int
+	flag.IntVar(&keep, "keep", -1,
+		"Number of the newest versions kept by prune")
+
+	var days int
+	flag.IntVar(&days, "days", 0,
+		"Prune versions not used for this many days")
+
+	var dry_run bool
+	flag.BoolVar(&dry_run, "n", false,
+		"Only print what prune and gc would remove")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(-1)
+	}
+
+	switch args[0] {
+	case "list":
+		list(versionArgs(args[1:]))
+	case "verify":
+		verify(versionArgs(args[1:]))
+	case "prune":
+		if keep < 0 && days <= 0 {
+			fmt.Println("prune needs -keep or -days")
+			os.Exit(-1)
+		}
+		versions, err := repolib.PruneVersions(keep,
+			time.Duration(days)*24*time.Hour)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, v := range versions {
+			remove(strconv.Itoa(v), dry_run)
+		}
+	case "gc":
+		paths, err := repolib.CacheLeftovers()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range paths {
+			remove(p, dry_run)
+		}
+	default:
+		fmt.Printf("Unknown action %s\n", args[0])
+		flag.Usage()
+		os.Exit(-1)
+	}
+}
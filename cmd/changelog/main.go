@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+type report struct {
+	From     int                     `json:"from"`
+	To       int                     `json:"to"`
+	Packages []repolib.SourceChanges `json:"packages"`
+}
+
+func main() {
+	var from_spec string
+	flag.StringVar(&from_spec, "from", "",
+		"Version to compare against, same values as -to")
+
+	var to_spec string
+	flag.StringVar(&to_spec, "to", "latest", common.VersionHelp)
+
+	var base_url string
+	flag.StringVar(&base_url, "url",
+		"https://cdn.download.clearlinux.org",
+		"Base URL downloading releases")
+
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var arch string
+	flag.StringVar(&arch, "arch", "",
+		"Architecture of the binary packages, overriding the layout")
+
+	var bundles bool
+	flag.BoolVar(&bundles, "bundles", false,
+		"Arguments are bundles rather than packages")
+
+	var print_json bool
+	flag.BoolVar(&print_json, "json", false,
+		"Print a JSON report of the CVEs fixed in each package")
+
+	var repo_keyring string
+	flag.StringVar(&repo_keyring, "repo_keyring", "",
+		"Only trust repo metadata signed by a key in this keyring")
+
+	var swupd_cert string
+	flag.StringVar(&swupd_cert, "swupd_cert", "",
+		"Only trust bundle metadata signed with this certificate")
+
+	flag.Usage = func() {
+		fmt.Printf("USAGE for %s -from version [options] packages...\n",
+			os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	downloader.Progress = os.Stderr
+
+	args := flag.Args()
+
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		log.Fatal()
+	}
+	if info.Mode()&os.ModeNamedPipe != 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			new_args := strings.Split(scanner.Text(), " ")
+			args = append(args, new_args...)
+		}
+	}
+
+	if from_spec == "" || len(args) == 0 {
+		flag.Usage()
+		os.Exit(-1)
+	}
+
+	repo_layout, err := common.LoadLayout(repo_layout_path, base_url, arch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	from, err := common.ResolveVersion(from_spec, repo_layout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	to, err := common.ResolveVersion(to_spec, repo_layout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	trust, err := repolib.LoadTrust(repo_keyring, swupd_cert)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, v := range []int{from, to} {
+		err = repolib.DownloadRepo(v, repo_layout, trust)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = repolib.DownloadSourceDatabases(v, repo_layout, trust,
+			"other", "filelists")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	packages := make(map[string]bool)
+	for _, arg := range args {
+		if arg == "" {
+			continue
+		}
+		if !bundles {
+			packages[arg] = true
+			continue
+		}
+		b, err := repolib.GetBundle(to, arg, repo_layout, trust)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for p := range b["AllPackages"].(map[string]interface{}) {
+			packages[p] = true
+		}
+	}
+
+	// Changelogs belong to the source packages the binaries are built from
+	srpmMap, err := repolib.GetPkgMap(to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	nevras, err := repolib.GetSrpmNevraMap(to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sourceNames := make(map[string]string)
+	for srpm, nevra := range nevras {
+		sourceNames[nevra.Name] = srpm
+	}
+
+	binaries := make(map[string][]string)
+	for p := range packages {
+		if srpm, ok := srpmMap[p]; ok && srpm != "" {
+			nevra, err := repolib.SrpmNevra(nevras, srpm)
+			if err != nil {
+				log.Fatal(err)
+			}
+			binaries[nevra.Name] = append(binaries[nevra.Name], p)
+		} else if sourceNames[p] != "" {
+			binaries[p] = append(binaries[p], p)
+		} else {
+			fmt.Println(repolib.SuggestFrom(
+				fmt.Sprintf("No package %s in version %d!", p, to), p,
+				srpmMap, sourceNames))
+			os.Exit(-1)
+		}
+	}
+
+	var names []string
+	for name := range binaries {
+		names = append(names, name)
+	}
+	changes, err := repolib.CompareSources(from, to, names)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i := range changes {
+		changes[i].Packages = binaries[changes[i].Name]
+		sort.Strings(changes[i].Packages)
+	}
+
+	if print_json {
+		out, err := json.MarshalIndent(report{from, to, changes}, "", "    ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	for _, c := range changes {
+		if len(c.Changelog) == 0 && len(c.Patches) == 0 {
+			continue
+		}
+		from_nvr := c.From
+		if from_nvr == "" {
+			from_nvr = "(new)"
+		}
+		fmt.Printf("%s: %s -> %s\n", c.Name, from_nvr, c.To)
+		if len(c.CVEs) > 0 {
+			fmt.Printf("  CVEs: %s\n", strings.Join(c.CVEs, " "))
+		}
+		for _, p := range c.Patches {
+			fmt.Printf("  New patch %s\n", p)
+		}
+		for _, e := range c.Changelog {
+			fmt.Printf("  * %s %s\n", e.Date.Format("Mon Jan 02 2006"),
+				e.Author)
+			for _, line := range strings.Split(e.Text, "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+		}
+	}
+}
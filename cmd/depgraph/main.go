@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/graph"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	var version_spec string
+	flag.StringVar(&version_spec, "clear_version", "installed",
+		common.VersionHelp)
+
+	var base_repo_url string
+	flag.StringVar(&base_repo_url, "repo_url",
+		"https://cdn.download.clearlinux.org",
+		"Base URL downloading releases")
+
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var arch string
+	flag.StringVar(&arch, "arch", "",
+		"Architecture of the binary packages, overriding the layout")
+
+	var image_name string
+	flag.StringVar(&image_name, "image", "",
+		"Graph the bundles of this image")
+
+	var format string
+	flag.StringVar(&format, "format", "dot",
+		"Output format: "+strings.Join(graph.Formats, ", "))
+
+	var types string
+	flag.StringVar(&types, "types", strings.Join(graph.Kinds, ","),
+		"Comma separated node types to include, paths through the others "+
+			"are shortened to direct edges")
+
+	var depth int
+	flag.IntVar(&depth, "depth", 0,
+		"Only include nodes this many edges away from the bundles, 0 for all")
+
+	var output string
+	flag.StringVar(&output, "o", "", "Write the graph to this file")
+
+	var repo_keyring string
+	flag.StringVar(&repo_keyring, "repo_keyring", "",
+		"Only trust repo metadata signed by a key in this keyring")
+
+	var swupd_cert string
+	flag.StringVar(&swupd_cert, "swupd_cert", "",
+		"Only trust bundle metadata signed with this certificate")
+
+	flag.Usage = func() {
+		fmt.Printf("USAGE for %s [options] bundles...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	downloader.Progress = os.Stderr
+
+	bundles := flag.Args()
+	if len(bundles) == 0 && image_name == "" {
+		flag.Usage()
+		os.Exit(-1)
+	}
+
+	valid_format := false
+	for _, f := range graph.Formats {
+		valid_format = valid_format || f == format
+	}
+	if !valid_format {
+		fmt.Printf("Unknown graph format %s, use %s\n", format,
+			strings.Join(graph.Formats, ", "))
+		os.Exit(-1)
+	}
+
+	kinds := make(map[string]bool)
+	for _, kind := range strings.Split(types, ",") {
+		known := false
+		for _, k := range graph.Kinds {
+			known = known || k == kind
+		}
+		if !known {
+			fmt.Printf("Unknown node type %s, use %s\n", kind,
+				strings.Join(graph.Kinds, ", "))
+			os.Exit(-1)
+		}
+		kinds[kind] = true
+	}
+
+	repo_layout, err := common.LoadLayout(repo_layout_path, base_repo_url, arch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	clear_version, err := common.ResolveVersion(version_spec, repo_layout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	trust, err := repolib.LoadTrust(repo_keyring, swupd_cert)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = repolib.DownloadRepo(clear_version, repo_layout, trust)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if image_name != "" {
+		image, err := repolib.GetImage(clear_version, image_name, repo_layout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bundles = append(bundles, image.Bundles()...)
+	}
+
+	g, err := repolib.BundleGraph(clear_version, bundles, repo_layout, trust)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	if kinds[graph.Srpm] {
+		err = repolib.AddSourceNodes(clear_version, g)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if depth > 0 {
+		var sources []graph.Node
+		for _, b := range bundles {
+			sources = append(sources, graph.Node{Kind: graph.Bundle, Name: b})
+		}
+		g = g.Within(sources, depth)
+	}
+	var selected []string
+	for _, k := range graph.Kinds {
+		if kinds[k] {
+			selected = append(selected, k)
+		}
+	}
+	g = g.Only(selected...)
+
+	w := os.Stdout
+	if output != "" {
+		w, err = os.Create(output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer w.Close()
+	}
+	err = graph.Write(w, g, format)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+}
@@ -4,25 +4,90 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
+	"github.com/dustin/go-humanize"
 	"github.com/intel/clear-linux-dissector/internal/common"
 	"github.com/intel/clear-linux-dissector/internal/downloader"
 	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"golang.org/x/crypto/openpgp"
+	"io/ioutil"
 	"log"
 	"os"
-	"strings"
-	"io/ioutil"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 )
 
+// checkSpace adds up the bytes needed on the filesystem of each path and
+// tells whether all of them have enough free space. The totals are printed
+// when verbose, or when they do not fit.
+func checkSpace(needs map[string]uint64, verbose bool) (bool, error) {
+	needed := make(map[uint64]uint64)
+	free := make(map[uint64]uint64)
+	paths := make(map[uint64][]string)
+	var devs []uint64
+	for path, size := range needs {
+		avail, dev, err := common.DiskSpace(path)
+		if err != nil {
+			return false, err
+		}
+		if _, ok := needed[dev]; !ok {
+			devs = append(devs, dev)
+		}
+		needed[dev] += size
+		free[dev] = avail
+		paths[dev] = append(paths[dev], path)
+	}
+	sort.Slice(devs, func(i, j int) bool { return devs[i] < devs[j] })
+
+	enough := true
+	for _, dev := range devs {
+		sort.Strings(paths[dev])
+		if needed[dev] > free[dev] {
+			enough = false
+		} else if !verbose {
+			continue
+		}
+		fmt.Printf("Disk space needed in %s: %s, %s free\n",
+			strings.Join(paths[dev], " and "),
+			humanize.Bytes(needed[dev]), humanize.Bytes(free[dev]))
+	}
+	return enough, nil
+}
+
+// origins maps the rpms to the name of the repo they come from
+func origins(sources *repolib.LayerRpms, downloads map[string]string) map[string]string {
+	r := make(map[string]string)
+	for fname := range downloads {
+		if l, ok := sources.Origins[fname]; ok {
+			r[fname] = l.Name
+		}
+	}
+	return r
+}
+
 func main() {
-	var clear_version int
-	flag.IntVar(&clear_version, "clear_version", -1, "Clear Linux version")
+	var version_spec string
+	flag.StringVar(&version_spec, "clear_version", "installed",
+		common.VersionHelp)
 
 	var base_repo_url string
 	flag.StringVar(&base_repo_url, "repo_url",
 		"https://cdn.download.clearlinux.org",
 		"Base URL downloading releases")
 
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var arch string
+	flag.StringVar(&arch, "arch", "",
+		"Architecture of the binary packages, overriding the layout")
+
+	var mix_path string
+	flag.StringVar(&mix_path, "mix", "",
+		"JSON description of a mix whose layered repos replace -repo_url "+
+			"and -repo_layout")
+
 	var base_bundles_url string
 	flag.StringVar(&base_bundles_url, "bundles_url",
 		"https://github.com/clearlinux/clr-bundles",
@@ -32,6 +97,51 @@ func main() {
 	flag.BoolVar(&download_all, "all", false,
 		"Download all sources for the release")
 
+	var reextract bool
+	flag.BoolVar(&reextract, "reextract", false,
+		"Extract sources again even if a complete tree already exists")
+
+	var keyring_path string
+	flag.StringVar(&keyring_path, "keyring", "",
+		"Verify source, binary and debuginfo rpm signatures against the keys in this keyring")
+
+	var repo_keyring string
+	flag.StringVar(&repo_keyring, "repo_keyring", "",
+		"Only trust repo metadata signed by a key in this keyring")
+
+	var swupd_cert string
+	flag.StringVar(&swupd_cert, "swupd_cert", "",
+		"Only trust bundle metadata signed with this certificate")
+
+	var image_name string
+	flag.StringVar(&image_name, "image", "",
+		"Dissect the bundles of this Clear Linux image")
+
+	var export_path string
+	flag.StringVar(&export_path, "export", "",
+		"Write the source rpms, an index and a README into this tar "+
+			"archive instead of extracting them")
+
+	var plan bool
+	flag.BoolVar(&plan, "plan", false,
+		"Only print what would be downloaded and extracted and the disk "+
+			"space needed")
+
+	var binary bool
+	flag.BoolVar(&binary, "binary", false,
+		"Download and extract the binary rpms of the packages instead of "+
+			"their source rpms")
+
+	var debug bool
+	flag.BoolVar(&debug, "debug", false,
+		"Download and extract the debuginfo rpms of the packages instead of "+
+			"their source rpms")
+
+	var layout string
+	flag.StringVar(&layout, "layout", repolib.LayoutName,
+		"Name source directories by package \"name\" or by "+
+			"\"nvr\" (name-version-release)")
+
 	flag.Usage = func() {
 		fmt.Printf("USAGE for %s\n", os.Args[0])
 		flag.PrintDefaults()
@@ -40,6 +150,21 @@ func main() {
 
 	args := flag.Args()
 
+	if layout != repolib.LayoutName && layout != repolib.LayoutNVR {
+		fmt.Printf("Unknown source layout %s\n", layout)
+		os.Exit(-1)
+	}
+
+	if binary && debug {
+		fmt.Println("Choose one of -binary and -debug")
+		os.Exit(-1)
+	}
+
+	if (binary || debug) && export_path != "" {
+		fmt.Println("Only source rpms can be exported")
+		os.Exit(-1)
+	}
+
 	info, err := os.Stdin.Stat()
 	if err != nil {
 		log.Fatal()
@@ -52,33 +177,76 @@ func main() {
 		}
 	}
 
-	if clear_version == -1 {
-		clear_version, err = common.GetInstalledVersion()
+	repo_layout, err := common.LoadLayout(repo_layout_path, base_repo_url, arch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	// A mix publishes its versions and bundles itself
+	var mix *repolib.Mix
+	if mix_path != "" {
+		mix, err = repolib.LoadMix(mix_path, arch)
 		if err != nil {
-			fmt.Println("A version must be specified when not " +
-				"running on a Clear Linux instance!")
+			fmt.Println(err)
 			os.Exit(-1)
 		}
+		repo_layout = mix.Layout
+	}
+
+	clear_version, err := common.ResolveVersion(version_spec, repo_layout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
 	}
 
-	err = repolib.DownloadRepo(clear_version, base_repo_url)
+	trust, err := repolib.LoadTrust(repo_keyring, swupd_cert)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	var keyring openpgp.EntityList
+	if keyring_path != "" {
+		keyring, err = repolib.LoadKeyring(keyring_path)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	layers := repolib.ReleaseLayers(clear_version, repo_layout)
+	if mix != nil {
+		layers = mix.Layers(clear_version)
+	}
+	err = repolib.DownloadLayers(clear_version, layers, trust)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if image_name != "" {
+		image, err := repolib.GetImage(clear_version, image_name, repo_layout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		args = append(args, image.Bundles()...)
+	}
+
 	// Query db for map of binary to source packages
-	srpmMap, err := repolib.GetPkgMap(clear_version)
+	srpmMap, err := repolib.GetLayerPkgMap(layers)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Query source package db for map of srpm to sha256
-	hashmap, err := repolib.GetSrpmHashMap(clear_version)
+	// Query source package dbs for what is known about each srpm
+	sources, err := repolib.GetLayerSources(layers)
 	if err != nil {
 		log.Fatal(err)
 	}
+	hashmap := sources.Hashes
 
-	if download_all {
+	// Source rpms of an older version that can be linked instead of
+	// downloaded again
+	reused := make(map[string]int)
+	if download_all && !binary && !debug {
 		// Find most recent version subdir with downloaded SRPMs
 		files, err := ioutil.ReadDir("./")
 		if err != nil {
@@ -115,6 +283,10 @@ func main() {
 							continue
 						}
 						if actual_checksum == hashmap[srpm] {
+							if plan {
+								reused[srpm] = maxoldver
+								continue
+							}
 							fmt.Printf("Copying previously downloaded %s\n", srpm)
 							os.Link(oldpth, newpth)
 						}
@@ -125,15 +297,17 @@ func main() {
 	}
 
 	downloads := make(map[string]string)
+	packages := make(map[string]string)
 	if download_all {
-		for _, srpm := range srpmMap {
-			downloads[srpm] = fmt.Sprintf("%s/releases/%d/clear/source/SRPMS/%s",
-				base_repo_url, clear_version, srpm)
+		for pkg, srpm := range srpmMap {
+			downloads[srpm] = sources.URL(layers, srpm)
+			packages[pkg] = srpm
 		}
 	} else {
 		requirements := make(map[string]bool)
 		for _, target_bundle := range args {
-			b, err := repolib.GetBundle(clear_version, target_bundle)
+			b, err := repolib.GetBundle(clear_version, target_bundle,
+				repo_layout, trust)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -143,22 +317,197 @@ func main() {
 			}
 		}
 
-		pkgs, err := repolib.QueryReqs(clear_version, requirements, "rpm_sourcerpm")
+		pkgs, err := repolib.QueryLayers(layers, requirements, "rpm_sourcerpm")
+		if err != nil {
+			log.Fatal(err)
+		}
+		// A requirement provided by several source rpms maps to the one
+		// the package map names, or else the first by name, so exports
+		// list the same INDEX every time
+		var srpms []string
+		for p := range pkgs {
+			srpms = append(srpms, p)
+		}
+		sort.Strings(srpms)
+		for _, p := range srpms {
+			downloads[p] = sources.URL(layers, p)
+			for _, r := range pkgs[p] {
+				if _, ok := packages[r]; !ok || srpmMap[r] == p {
+					packages[r] = p
+				}
+			}
+		}
+	}
+
+	// Binary mode fetches the packages themselves rather than the source
+	// rpms they were built from, debug mode their debuginfo rpms
+	kind, rpm_dir, tree_dir := "source", "srpms", "source"
+	debug_index := make(map[string]string)
+	if binary {
+		kind, rpm_dir, tree_dir = "binary", "rpms", "binary"
+		rpms, files, err := repolib.GetLayerBinaries(layers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		downloads = make(map[string]string)
+		for pkg := range packages {
+			fname, ok := files[pkg]
+			if !ok {
+				fmt.Printf("No binary rpm found for %s!\n", pkg)
+				os.Exit(-1)
+			}
+			downloads[fname] = rpms.URL(layers, fname)
+		}
+		sources = rpms
+		hashmap = rpms.Hashes
+	} else if debug {
+		kind, rpm_dir, tree_dir = "debuginfo", "rpms", "debug"
+		err = repolib.DownloadDebugLayers(layers, trust)
 		if err != nil {
 			log.Fatal(err)
 		}
-		for _, p := range pkgs {
-			downloads[p] = fmt.Sprintf("%s/releases/%d/clear/source/SRPMS/%s",
-				base_repo_url, clear_version, p)
+		rpms, found, err := repolib.GetLayerDebugRpms(layers, packages)
+		if err != nil {
+			log.Fatal(err)
+		}
+		downloads = make(map[string]string)
+		var missing []string
+		for pkg := range packages {
+			d, ok := found[pkg]
+			if !ok {
+				missing = append(missing, pkg)
+				continue
+			}
+			downloads[d.File] = rpms.URL(layers, d.File)
+			debug_index[repolib.FormatNevra(d.Package)] = d.File
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			fmt.Printf("No debuginfo found for %s\n", strings.Join(missing, ", "))
+		}
+		sources = rpms
+		hashmap = rpms.Hashes
+	}
+	if binary || debug {
+		for _, dir := range []string{rpm_dir, tree_dir} {
+			err = os.MkdirAll(fmt.Sprintf("%d/%s", clear_version, dir), 0700)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	// Work out all the targets first so colliding rpms are found
+	// before anything is downloaded
+	targets := make(map[string]string)
+	if export_path == "" {
+		dirs := make(map[string]string)
+		for fname := range downloads {
+			nevra, err := repolib.SrpmNevra(sources.Nevras, fname)
+			if err != nil {
+				log.Fatal(err)
+			}
+			dir, err := repolib.SourceDir(nevra, layout)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if other, ok := dirs[dir]; ok {
+				fmt.Printf("%s and %s both extract to %s, use -layout %s\n",
+					other, fname, dir, repolib.LayoutNVR)
+				os.Exit(-1)
+			}
+			dirs[dir] = fname
+			targets[fname] = fmt.Sprintf("%d/%s/%s", clear_version, tree_dir, dir)
+		}
+	}
+
+	var names []string
+	for fname := range downloads {
+		names = append(names, fname)
+	}
+	sort.Strings(names)
+
+	var download_size, extract_size, export_size uint64
+	fetch_count, extract_count := 0, 0
+	for _, fname := range names {
+		size := sources.Sizes[fname]
+		export_size += uint64(size.Package)
+
+		target := fmt.Sprintf("%d/%s/%s", clear_version, rpm_dir, fname)
+		if _, err := os.Stat(target); err == nil {
+			if plan {
+				fmt.Printf("cached %s\n", fname)
+			}
+		} else if v, ok := reused[fname]; ok {
+			if plan {
+				fmt.Printf("reuse %s from %d\n", fname, v)
+			}
+		} else {
+			fetch_count++
+			download_size += uint64(size.Package)
+			if plan && mix != nil {
+				fmt.Printf("download %s from %s (%s)\n", fname,
+					sources.Origins[fname].Name,
+					humanize.Bytes(uint64(size.Package)))
+			} else if plan {
+				fmt.Printf("download %s (%s)\n", fname,
+					humanize.Bytes(uint64(size.Package)))
+			}
+		}
+
+		if export_path != "" {
+			continue
+		}
+		if !reextract && repolib.IsExtracted(targets[fname], hashmap[fname]) {
+			if plan {
+				fmt.Printf("up to date %s\n", targets[fname])
+			}
+			continue
+		}
+		extract_count++
+		extract_size += uint64(size.Installed)
+		if plan {
+			fmt.Printf("extract %s to %s (%s)\n", fname, targets[fname],
+				humanize.Bytes(uint64(size.Installed)))
 		}
 	}
 
-	// Download the source rpms
+	needs := map[string]uint64{".": download_size + extract_size}
+	if plan {
+		fmt.Printf("%s rpms: %d, %d to download (%s), %d cached\n",
+			strings.Title(kind), len(names), fetch_count, humanize.Bytes(download_size),
+			len(names)-fetch_count)
+		if export_path == "" {
+			fmt.Printf("%s trees: %d to extract (%s), %d up to date\n",
+				strings.Title(kind), extract_count, humanize.Bytes(extract_size),
+				len(names)-extract_count)
+		}
+	}
+	if export_path != "" {
+		if plan {
+			fmt.Printf("Archive: %s (%s)\n", export_path,
+				humanize.Bytes(export_size))
+		}
+		needs[filepath.Dir(export_path)] += export_size
+	}
+	enough, err := checkSpace(needs, plan)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !enough {
+		fmt.Println("Not enough free disk space!")
+		os.Exit(-1)
+	}
+	if plan {
+		return
+	}
+
+	// Download the rpms
 	i := 0
 	dlcount := len(downloads)
 	for fname, url := range downloads {
 		i++
-		target := fmt.Sprintf("%d/srpms/%s", clear_version, fname)
+		target := fmt.Sprintf("%d/%s/%s", clear_version, rpm_dir, fname)
 		if _, err := os.Stat(target); !os.IsNotExist(err) {
 			continue
 		}
@@ -173,7 +522,7 @@ func main() {
 		}
 	}
 
-	if download_all {
+	if download_all && !binary && !debug {
 		// We're done downloading srpms, mark the directory as done
 		dotfpath := fmt.Sprintf("%d/srpms/.done", clear_version)
 		f, err := os.OpenFile(dotfpath, os.O_WRONLY|os.O_CREATE, 0644)
@@ -185,23 +534,84 @@ func main() {
 		}
 	}
 
-	// Unarchive the source rpms
+	if keyring != nil {
+		var paths []string
+		for fname := range downloads {
+			paths = append(paths, fmt.Sprintf("%d/%s/%s", clear_version,
+				rpm_dir, fname))
+		}
+		failures := repolib.VerifyRpms(paths, keyring)
+		if len(failures) > 0 {
+			repolib.ReportVerifyFailures(failures)
+			fmt.Printf("%d %s rpms failed signature verification!\n",
+				len(failures), kind)
+			os.Exit(-1)
+		}
+	}
+
+	if export_path != "" {
+		archive := repolib.SourceArchive{
+			Version:  clear_version,
+			Image:    image_name,
+			Bundles:  args,
+			URL:      repo_layout.SourceRepoURL(clear_version) + "/",
+			Packages: packages,
+			Srpms:    make(map[string]string),
+			Licenses: sources.Licenses,
+		}
+		for fname := range downloads {
+			archive.Srpms[fname] = hashmap[fname]
+		}
+		if mix != nil {
+			archive.Name = mix.Name
+			archive.Repos = origins(sources, downloads)
+			archive.RepoURLs = make(map[string]string)
+			for _, l := range layers {
+				archive.RepoURLs[l.Name] = l.Layout.SourceRepoURL(l.Version) + "/"
+			}
+		}
+		err = repolib.WriteSourceArchive(export_path, &archive)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Wrote %d source rpms to %s\n", len(downloads), export_path)
+		return
+	}
+
+	// Unarchive the rpms
 	i = 0
 	for fname := range downloads {
 		i++
-		archive := fmt.Sprintf("%d/srpms/%s", clear_version, fname)
-		target := fmt.Sprintf("%d/source/%s", clear_version,
-			strings.TrimSuffix(fname, ".src.rpm"))
+		archive := fmt.Sprintf("%d/%s/%s", clear_version, rpm_dir, fname)
+		target := targets[fname]
+
+		// A tree is only reused when it was completely extracted from
+		// the same SRPM we have now
+		if reextract || !repolib.IsExtracted(target, hashmap[fname]) {
+			fmt.Printf("Extracting (%d/%d) %s to %s...\n", i, dlcount, archive, target)
+			err = repolib.ExtractRpm(archive, target, reextract)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
 
-		// Remove the version and release sections from the name
-		l := strings.Split(target, "-")
-		target = strings.Join(l[:len(l)-2], "-")
+		// Trees of a mix record the repo their rpm came from
+		if mix != nil {
+			err = repolib.RecordOrigin(target, sources.Origins[fname].Name)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
 
-		if _, err := os.Stat(target); !os.IsNotExist(err) {
-			continue
+	// The debug trees are found through the NEVRAs of the packages
+	if debug {
+		entries := make(map[string]string)
+		for nevra, fname := range debug_index {
+			entries[nevra] = filepath.Base(targets[fname])
 		}
-		fmt.Printf("Extracting (%d/%d) %s to %s...\n", i, dlcount, archive, target)
-		err = repolib.ExtractRpm(archive, target)
+		err = repolib.UpdateDebugIndex(fmt.Sprintf("%d/%s/INDEX",
+			clear_version, tree_dir), entries)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -7,24 +7,49 @@ import (
 	"github.com/intel/clear-linux-dissector/internal/common"
 	"github.com/intel/clear-linux-dissector/internal/downloader"
 	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"golang.org/x/crypto/openpgp"
 	"log"
 	"os"
 	"strings"
 )
 
 func main() {
-	var clear_version int
-	flag.IntVar(&clear_version, "clear_version", -1, "Clear Linux version")
+	var version_spec string
+	flag.StringVar(&version_spec, "clear_version", "installed",
+		common.VersionHelp)
 
 	var base_url string
 	flag.StringVar(&base_url, "url",
 		"https://cdn.download.clearlinux.org",
 		"Base URL for downloading release source rpms")
 
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var arch string
+	flag.StringVar(&arch, "arch", "",
+		"Architecture of the binary packages, overriding the layout")
+
+	var binary bool
+	flag.BoolVar(&binary, "binary", false,
+		"Download the binary rpms of the packages instead of their source rpms")
+
 	var skip_download bool
 	flag.BoolVar(&skip_download, "skip", false,
 		"Skip downloading any source rpm files")
 
+	var keyring_path string
+	flag.StringVar(&keyring_path, "keyring", "",
+		"Verify source and binary rpm signatures against the keys in this keyring")
+
+	var repo_keyring string
+	flag.StringVar(&repo_keyring, "repo_keyring", "",
+		"Only trust repo metadata signed by a key in this keyring")
+
+	var swupd_cert string
+	flag.StringVar(&swupd_cert, "swupd_cert", "",
+		"Only trust bundle metadata signed with this certificate")
+
 	flag.Usage = func() {
 		fmt.Printf("USAGE for %s\n", os.Args[0])
 		flag.PrintDefaults()
@@ -45,17 +70,33 @@ func main() {
 		}
 	}
 
-	if clear_version == -1 {
-		clear_version, err = common.GetInstalledVersion()
+	repo_layout, err := common.LoadLayout(repo_layout_path, base_url, arch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	clear_version, err := common.ResolveVersion(version_spec, repo_layout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	trust, err := repolib.LoadTrust(repo_keyring, swupd_cert)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var keyring openpgp.EntityList
+	if keyring_path != "" {
+		keyring, err = repolib.LoadKeyring(keyring_path)
 		if err != nil {
-			fmt.Println("A version must be specified when not " +
-				"running on a Clear Linux instance!")
-			os.Exit(-1)
+			log.Fatal(err)
 		}
 	}
 
 	// Download repo data if needed and initialize directory structure
-	err = repolib.DownloadRepo(clear_version, base_url)
+	err = repolib.DownloadRepo(clear_version, repo_layout, trust)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -72,21 +113,47 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Binary rpms are kept apart from the source rpms in rpms/
+	kind, rpm_dir := "source", "source"
 	downloads := make(map[string]string)
-	for _, p := range args {
-		if srpmMap[p] == "" {
-			fmt.Printf("No mapping found for %s!\n", p)
-			os.Exit(-1)
+	if binary {
+		kind, rpm_dir = "binary", "rpms"
+		layers := repolib.ReleaseLayers(clear_version, repo_layout)
+		rpms, files, err := repolib.GetLayerBinaries(layers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = os.MkdirAll(fmt.Sprintf("%d/%s", clear_version, rpm_dir), 0700)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range args {
+			fname, ok := files[p]
+			if !ok {
+				fmt.Println(repolib.SuggestFrom(
+					fmt.Sprintf("No binary rpm found for %s!", p), p, files))
+				os.Exit(-1)
+			}
+			downloads[fname] = rpms.URLs[fname]
+		}
+		hashmap = rpms.Hashes
+	} else {
+		for _, p := range args {
+			if srpmMap[p] == "" {
+				fmt.Println(repolib.SuggestFrom(
+					fmt.Sprintf("No mapping found for %s!", p), p, srpmMap))
+				os.Exit(-1)
+			}
+			downloads[srpmMap[p]] = repo_layout.SrpmURL(clear_version,
+				srpmMap[p])
 		}
-		downloads[srpmMap[p]] = fmt.Sprintf("%s/releases/%d/clear/source/SRPMS/%s",
-			base_url, clear_version, srpmMap[p])
 	}
 
 	i := 0
 	dlcount := len(downloads)
 	for fname, url := range downloads {
 		i++
-		target := fmt.Sprintf("%d/source/%s", clear_version, fname)
+		target := fmt.Sprintf("%d/%s/%s", clear_version, rpm_dir, fname)
 		if skip_download == true {
 			fmt.Printf("Skipping %s\n", url)
 			continue
@@ -104,4 +171,19 @@ func main() {
 			log.Fatal(err)
 		}
 	}
+
+	if keyring != nil && !skip_download {
+		var paths []string
+		for fname := range downloads {
+			paths = append(paths, fmt.Sprintf("%d/%s/%s", clear_version,
+				rpm_dir, fname))
+		}
+		failures := repolib.VerifyRpms(paths, keyring)
+		if len(failures) > 0 {
+			repolib.ReportVerifyFailures(failures)
+			fmt.Printf("%d %s rpms failed signature verification!\n",
+				len(failures), kind)
+			os.Exit(-1)
+		}
+	}
 }
@@ -12,14 +12,30 @@ import (
 )
 
 func main() {
-	var clear_version int
-	flag.IntVar(&clear_version, "clear_version", -1, "Clear Linux version")
+	var version_spec string
+	flag.StringVar(&version_spec, "clear_version", "installed",
+		common.VersionHelp)
 
 	var base_url string
 	flag.StringVar(&base_url, "url",
 		"https://cdn.download.clearlinux.org",
 		"Base URL downloading releases")
 
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var arch string
+	flag.StringVar(&arch, "arch", "",
+		"Architecture of the binary packages, overriding the layout")
+
+	var repo_keyring string
+	flag.StringVar(&repo_keyring, "repo_keyring", "",
+		"Only trust repo metadata signed by a key in this keyring")
+
+	var swupd_cert string
+	flag.StringVar(&swupd_cert, "swupd_cert", "",
+		"Only trust bundle metadata signed with this certificate")
+
 	flag.Usage = func() {
 		fmt.Printf("USAGE for %s\n", os.Args[0])
 		flag.PrintDefaults()
@@ -40,16 +56,24 @@ func main() {
 		}
 	}
 
-	if clear_version == -1 {
-		clear_version, err = common.GetInstalledVersion()
-		if err != nil {
-			fmt.Println("A version must be specified when not " +
-				"running on a Clear Linux instance!")
-			os.Exit(-1)
-		}
+	repo_layout, err := common.LoadLayout(repo_layout_path, base_url, arch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	clear_version, err := common.ResolveVersion(version_spec, repo_layout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	trust, err := repolib.LoadTrust(repo_keyring, swupd_cert)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	err = repolib.DownloadRepo(clear_version, base_url)
+	err = repolib.DownloadRepo(clear_version, repo_layout, trust)
 	if err != nil {
 		log.Fatal(err)
 	}
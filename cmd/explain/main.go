@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/graph"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"log"
+	"os"
+)
+
+func printPath(path []graph.Edge) {
+	for _, e := range path {
+		reason := e.Label()
+		if e.From.Kind == graph.Package {
+			reason = "requires " + reason
+		}
+		fmt.Printf("%s -> %s (%s)\n", e.From.Name, e.To.Name, reason)
+	}
+}
+
+func main() {
+	var version_spec string
+	flag.StringVar(&version_spec, "clear_version", "installed",
+		common.VersionHelp)
+
+	var base_repo_url string
+	flag.StringVar(&base_repo_url, "repo_url",
+		"https://cdn.download.clearlinux.org",
+		"Base URL downloading releases")
+
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var arch string
+	flag.StringVar(&arch, "arch", "",
+		"Architecture of the binary packages, overriding the layout")
+
+	var image_name string
+	flag.StringVar(&image_name, "image", "",
+		"Explain the package in the bundles of this image")
+
+	var all_paths bool
+	flag.BoolVar(&all_paths, "all", false,
+		"Print all dependency paths, not only the shortest")
+
+	var limit int
+	flag.IntVar(&limit, "limit", 20,
+		"Maximum number of paths printed with -all")
+
+	var repo_keyring string
+	flag.StringVar(&repo_keyring, "repo_keyring", "",
+		"Only trust repo metadata signed by a key in this keyring")
+
+	var swupd_cert string
+	flag.StringVar(&swupd_cert, "swupd_cert", "",
+		"Only trust bundle metadata signed with this certificate")
+
+	flag.Usage = func() {
+		fmt.Printf("USAGE for %s [options] package bundles...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	downloader.Progress = os.Stderr
+
+	args := flag.Args()
+	if len(args) == 0 || (len(args) == 1 && image_name == "") {
+		flag.Usage()
+		os.Exit(-1)
+	}
+	target := args[0]
+	bundles := args[1:]
+
+	if limit < 1 {
+		fmt.Println("-limit must be at least 1")
+		os.Exit(-1)
+	}
+
+	repo_layout, err := common.LoadLayout(repo_layout_path, base_repo_url, arch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	clear_version, err := common.ResolveVersion(version_spec, repo_layout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	trust, err := repolib.LoadTrust(repo_keyring, swupd_cert)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = repolib.DownloadRepo(clear_version, repo_layout, trust)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if image_name != "" {
+		image, err := repolib.GetImage(clear_version, image_name, repo_layout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bundles = append(bundles, image.Bundles()...)
+	}
+
+	g, err := repolib.BundleGraph(clear_version, bundles, repo_layout, trust)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	node := graph.Node{Kind: graph.Package, Name: target}
+	if !g.HasNode(node) {
+		node = graph.Node{Kind: graph.Bundle, Name: target}
+	}
+	if !g.HasNode(node) {
+		fmt.Println(repolib.AddSuggestion(
+			fmt.Sprintf("%s is not part of the bundles in version %d!", target,
+				clear_version), target, g.Names()))
+		os.Exit(-1)
+	}
+
+	var sources []graph.Node
+	for _, b := range bundles {
+		sources = append(sources, graph.Node{Kind: graph.Bundle, Name: b})
+	}
+
+	if !all_paths {
+		path := g.ShortestPath(sources, node)
+		if len(path) == 0 {
+			fmt.Printf("%s is one of the requested bundles\n", target)
+			return
+		}
+		printPath(path)
+		return
+	}
+
+	for i, path := range g.AllPaths(sources, node, limit) {
+		if i > 0 {
+			fmt.Println()
+		}
+		if len(path) == 0 {
+			fmt.Printf("%s is one of the requested bundles\n", target)
+			continue
+		}
+		printPath(path)
+	}
+}
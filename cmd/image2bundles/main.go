@@ -5,15 +5,15 @@ import (
 	"flag"
 	"fmt"
 	"github.com/intel/clear-linux-dissector/internal/common"
-	"io/ioutil"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
 	"log"
-	"net/http"
 	"os"
+	"strings"
 )
 
 func main() {
-	var clear_version int
-	flag.IntVar(&clear_version, "v", -1, "Clear Linux version")
+	var version_spec string
+	flag.StringVar(&version_spec, "v", "installed", common.VersionHelp)
 
 	var image_name string
 	flag.StringVar(&image_name, "n", "", "Name of Clear Linux image")
@@ -22,47 +22,82 @@ func main() {
 	flag.StringVar(&base_url, "u", "https://cdn.download.clearlinux.org/releases",
 		"Base URL for Clear repository")
 
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var config_file string
+	flag.StringVar(&config_file, "f", "",
+		"Read the image definition from a local file instead of the server")
+
+	var list_images bool
+	flag.BoolVar(&list_images, "l", false,
+		"List the images available for the version")
+
+	var print_json bool
+	flag.BoolVar(&print_json, "j", false,
+		"Print the whole image definition as JSON")
+
 	flag.Usage = func() {
 		fmt.Printf("USAGE for %s\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 
-	if clear_version == -1 {
-		var err error
-		clear_version, err = common.GetInstalledVersion()
+	if !list_images && image_name == "" && config_file == "" {
+		fmt.Println("An image name (-n) or definition file (-f) is required!")
+		flag.Usage()
+		os.Exit(-1)
+	}
+
+	// The layout templates start from the server rather than its releases
+	repo_layout, err := common.LoadLayout(repo_layout_path,
+		strings.TrimSuffix(base_url, "/releases"), "")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	var clear_version int
+	if list_images || config_file == "" {
+		clear_version, err = common.ResolveVersion(version_spec, repo_layout)
 		if err != nil {
-			fmt.Println("A version must be specified when not " +
-				"running on a Clear Linux instance!")
+			fmt.Println(err)
 			os.Exit(-1)
 		}
 	}
 
-	config_url := fmt.Sprintf("%s/%d/clear/config/image/%s-config.json",
-		base_url, clear_version, image_name)
-
-	resp, err := http.Get(config_url)
-	if err != nil {
-		log.Fatal(err)
-
+	if list_images {
+		images, err := repolib.ListImages(clear_version, repo_layout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, name := range images {
+			fmt.Println(name)
+		}
+		return
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	var image *repolib.Image
+	if config_file != "" {
+		image, err = repolib.ReadImage(config_file)
+	} else {
+		image, err = repolib.GetImage(clear_version, image_name, repo_layout)
+	}
 	if err != nil {
-		log.Fatal(err)
+		fmt.Println(err)
+		os.Exit(-1)
 	}
 
-	if resp.Status != "200 OK" {
-		fmt.Printf("Image \"%s\" for version %d was not found on the server\n",
-			image_name, clear_version)
-		os.Exit(-1)
+	if print_json {
+		out, err := json.MarshalIndent(image, "", "    ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+		return
 	}
 
-	var config map[string]interface{}
-	json.Unmarshal(body, &config)
-	bundles := config["Bundles"].([]interface{})
-	for _, value := range bundles {
+	for _, value := range image.Bundles() {
 		fmt.Println(value)
 	}
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+)
+
+func main() {
+	var base_url string
+	flag.StringVar(&base_url, "url",
+		"https://cdn.download.clearlinux.org",
+		"Base URL of the CDN to mirror")
+
+	var addr string
+	flag.StringVar(&addr, "addr", ":8080", "Address to listen on")
+
+	var cache_dir string
+	flag.StringVar(&cache_dir, "cache", "mirror",
+		"Directory the mirrored files missing from the version cache are kept in")
+
+	var repo_keyring string
+	flag.StringVar(&repo_keyring, "repo_keyring", "",
+		"Only trust repo metadata signed by a key in this keyring")
+
+	var swupd_cert string
+	flag.StringVar(&swupd_cert, "swupd_cert", "",
+		"Only trust bundle metadata signed with this certificate")
+
+	flag.Usage = func() {
+		fmt.Printf("USAGE for %s\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// The request log goes to stderr, keep download progress out of it
+	downloader.Progress = ioutil.Discard
+
+	trust, err := repolib.LoadTrust(repo_keyring, swupd_cert)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = os.MkdirAll(cache_dir, 0755)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mirror := downloader.NewMirror(base_url, cache_dir)
+	mirror.Verify = repolib.MirrorVerifier(cache_dir, base_url, trust)
+	mirror.Local = repolib.VersionCachePaths
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Mirroring %s on http://%s\n", base_url, listener.Addr())
+
+	log.Fatal(http.Serve(listener, mirror))
+}
@@ -12,14 +12,22 @@ import (
 )
 
 func main() {
-	var clear_version int
-	flag.IntVar(&clear_version, "clear_version", -1, "Clear Linux version")
+	var version_spec string
+	flag.StringVar(&version_spec, "clear_version", "installed",
+		common.VersionHelp)
 
 	var base_repo_url string
 	flag.StringVar(&base_repo_url, "repo_url",
 		"https://cdn.download.clearlinux.org",
 		"Base URL downloading releases")
 
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var arch string
+	flag.StringVar(&arch, "arch", "",
+		"Architecture of the binary packages, overriding the layout")
+
 	flag.Usage = func() {
 		fmt.Printf("USAGE for %s\n", os.Args[0])
 		flag.PrintDefaults()
@@ -40,13 +48,16 @@ func main() {
 		}
 	}
 
-	if clear_version == -1 {
-		clear_version, err = common.GetInstalledVersion()
-		if err != nil {
-			fmt.Println("A version must be specified when not " +
-				"running on a Clear Linux instance!")
-			os.Exit(-1)
-		}
+	repo_layout, err := common.LoadLayout(repo_layout_path, base_repo_url, arch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	clear_version, err := common.ResolveVersion(version_spec, repo_layout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
 	}
 
 	// Query db for map of binary to source packages
@@ -57,10 +68,10 @@ func main() {
 
 	for _, p := range args {
 		if srpmMap[p] == "" {
-			fmt.Printf("No mapping found for %s!\n", p)
+			fmt.Println(repolib.SuggestFrom(
+				fmt.Sprintf("No mapping found for %s!", p), p, srpmMap))
 			os.Exit(-1)
 		}
-		fmt.Println(fmt.Sprintf("%s/releases/%d/clear/source/SRPMS/%s",
-			base_repo_url, clear_version, srpmMap[p]))
+		fmt.Println(repo_layout.SrpmURL(clear_version, srpmMap[p]))
 	}
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"log"
+	"os"
+	"strings"
+)
+
+func printInfo(p *repolib.PackageInfo) {
+	version := p.Version
+	if p.Epoch != "" {
+		version = p.Epoch + ":" + version
+	}
+
+	fmt.Printf("Name         : %s\n", p.Name)
+	fmt.Printf("Version      : %s\n", version)
+	fmt.Printf("Release      : %s\n", p.Release)
+	fmt.Printf("Architecture : %s\n", p.Arch)
+	fmt.Printf("Size         : %s\n", humanize.Bytes(uint64(p.PackageSize)))
+	fmt.Printf("Installed    : %s\n", humanize.Bytes(uint64(p.InstalledSize)))
+	fmt.Printf("Source       : %s\n", p.SourceRpm)
+	fmt.Printf("Summary      : %s\n", p.Summary)
+	fmt.Printf("URL          : %s\n", p.URL)
+	fmt.Printf("License      : %s\n", p.License)
+	fmt.Printf("Provides     : %s\n", strings.Join(p.Provides, "\n               "))
+	fmt.Printf("Requires     : %s\n", strings.Join(p.Requires, "\n               "))
+	fmt.Printf("Description  : %s\n", strings.Replace(p.Description, "\n",
+		"\n               ", -1))
+}
+
+func main() {
+	var version_spec string
+	flag.StringVar(&version_spec, "clear_version", "installed",
+		common.VersionHelp)
+
+	var base_repo_url string
+	flag.StringVar(&base_repo_url, "repo_url",
+		"https://cdn.download.clearlinux.org",
+		"Base URL downloading releases")
+
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var arch string
+	flag.StringVar(&arch, "arch", "",
+		"Architecture of the binary packages, overriding the layout")
+
+	var print_json bool
+	flag.BoolVar(&print_json, "json", false,
+		"Print the package information as JSON")
+
+	var repo_keyring string
+	flag.StringVar(&repo_keyring, "repo_keyring", "",
+		"Only trust repo metadata signed by a key in this keyring")
+
+	flag.Usage = func() {
+		fmt.Printf("USAGE for %s\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	downloader.Progress = os.Stderr
+
+	args := flag.Args()
+
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		log.Fatal()
+	}
+	if info.Mode()&os.ModeNamedPipe != 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			new_args := strings.Split(scanner.Text(), " ")
+			args = append(args, new_args...)
+		}
+	}
+
+	var names []string
+	for _, arg := range args {
+		if arg != "" {
+			names = append(names, arg)
+		}
+	}
+	if len(names) == 0 {
+		flag.Usage()
+		os.Exit(-1)
+	}
+
+	repo_layout, err := common.LoadLayout(repo_layout_path, base_repo_url, arch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	clear_version, err := common.ResolveVersion(version_spec, repo_layout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	trust, err := repolib.LoadTrust(repo_keyring, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = repolib.DownloadRepo(clear_version, repo_layout, trust)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	packages, err := repolib.GetPackageInfo(clear_version, names)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	all_names, err := repolib.PackageNames(clear_version)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var found []*repolib.PackageInfo
+	missing := 0
+	for _, name := range names {
+		p, ok := packages[name]
+		if !ok {
+			fmt.Fprintln(os.Stderr, repolib.AddSuggestion(
+				fmt.Sprintf("No package %s in version %d!", name, clear_version),
+				name, all_names))
+			missing++
+			continue
+		}
+		found = append(found, p)
+	}
+
+	if print_json {
+		out, err := json.MarshalIndent(found, "", "    ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+	} else {
+		for i, p := range found {
+			if i > 0 {
+				fmt.Println()
+			}
+			printInfo(p)
+		}
+	}
+
+	if missing > 0 {
+		os.Exit(-1)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+func main() {
+	var version_spec string
+	flag.StringVar(&version_spec, "clear_version", "installed",
+		common.VersionHelp)
+
+	var base_repo_url string
+	flag.StringVar(&base_repo_url, "repo_url",
+		"https://cdn.download.clearlinux.org",
+		"Base URL downloading releases")
+
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var arch string
+	flag.StringVar(&arch, "arch", "",
+		"Architecture of the binary packages, overriding the layout")
+
+	var use_regex bool
+	flag.BoolVar(&use_regex, "regex", false,
+		"Terms are regular expressions rather than substrings")
+
+	var case_sensitive bool
+	flag.BoolVar(&case_sensitive, "case", false, "Match case sensitively")
+
+	var fields string
+	flag.StringVar(&fields, "fields", strings.Join(repolib.SearchFields, ","),
+		"Comma separated package fields to search")
+
+	var print_json bool
+	flag.BoolVar(&print_json, "json", false, "Print the matches as JSON")
+
+	var repo_keyring string
+	flag.StringVar(&repo_keyring, "repo_keyring", "",
+		"Only trust repo metadata signed by a key in this keyring")
+
+	flag.Usage = func() {
+		fmt.Printf("USAGE for %s [options] terms...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	downloader.Progress = os.Stderr
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(-1)
+	}
+
+	// Packages have to match every term
+	var patterns []*regexp.Regexp
+	for _, term := range flag.Args() {
+		if !use_regex {
+			term = regexp.QuoteMeta(term)
+		}
+		if !case_sensitive {
+			term = "(?i)" + term
+		}
+		re, err := regexp.Compile(term)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		patterns = append(patterns, re)
+	}
+
+	repo_layout, err := common.LoadLayout(repo_layout_path, base_repo_url, arch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	clear_version, err := common.ResolveVersion(version_spec, repo_layout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	trust, err := repolib.LoadTrust(repo_keyring, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = repolib.DownloadRepo(clear_version, repo_layout, trust)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var results []repolib.SearchResult
+	for i, re := range patterns {
+		matches, err := repolib.SearchPackages(clear_version, re,
+			strings.Split(fields, ","))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if i == 0 {
+			results = matches
+			continue
+		}
+
+		found := make(map[string]repolib.SearchResult)
+		for _, m := range matches {
+			found[m.Name] = m
+		}
+		var both []repolib.SearchResult
+		for _, r := range results {
+			m, ok := found[r.Name]
+			if !ok {
+				continue
+			}
+			matched := make(map[string]bool)
+			for _, f := range r.Matches {
+				matched[f] = true
+			}
+			for _, f := range m.Matches {
+				if !matched[f] {
+					r.Matches = append(r.Matches, f)
+				}
+			}
+			both = append(both, r)
+		}
+		results = both
+	}
+
+	if print_json {
+		if results == nil {
+			results = []repolib.SearchResult{}
+		}
+		out, err := json.MarshalIndent(results, "", "    ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+	} else {
+		for _, r := range results {
+			fmt.Printf("%s-%s-%s : %s\n", r.Name, r.Version, r.Release,
+				r.Summary)
+		}
+	}
+
+	if len(results) == 0 {
+		fmt.Fprintf(os.Stderr, "No packages matching %s in version %d\n",
+			strings.Join(flag.Args(), " "), clear_version)
+		os.Exit(-1)
+	}
+}
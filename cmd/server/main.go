@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"github.com/intel/clear-linux-dissector/internal/server"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func main() {
+	var version_specs string
+	flag.StringVar(&version_specs, "clear_version", "installed",
+		"Comma separated Clear Linux versions to serve, each a number, "+
+			"installed, latest, latest-N or formatN")
+
+	var addr string
+	flag.StringVar(&addr, "addr", "localhost:8080",
+		"Address to listen on")
+
+	var base_repo_url string
+	flag.StringVar(&base_repo_url, "repo_url",
+		"https://cdn.download.clearlinux.org",
+		"Base URL downloading releases")
+
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var arch string
+	flag.StringVar(&arch, "arch", "",
+		"Architecture of the binary packages, overriding the layout")
+
+	var filelists bool
+	flag.BoolVar(&filelists, "filelists", true,
+		"Download the complete file lists to look up paths")
+
+	var repo_keyring string
+	flag.StringVar(&repo_keyring, "repo_keyring", "",
+		"Only trust repo metadata signed by a key in this keyring")
+
+	var swupd_cert string
+	flag.StringVar(&swupd_cert, "swupd_cert", "",
+		"Only trust bundle metadata signed with this certificate")
+
+	flag.Usage = func() {
+		fmt.Printf("USAGE for %s\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	downloader.Progress = os.Stderr
+
+	repo_layout, err := common.LoadLayout(repo_layout_path, base_repo_url, arch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	trust, err := repolib.LoadTrust(repo_keyring, swupd_cert)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Cache everything the queries need before serving
+	var versions []int
+	seen := make(map[int]bool)
+	for _, spec := range strings.Split(version_specs, ",") {
+		clear_version, err := common.ResolveVersion(strings.TrimSpace(spec),
+			repo_layout)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		if seen[clear_version] {
+			continue
+		}
+		seen[clear_version] = true
+
+		err = repolib.DownloadRepo(clear_version, repo_layout, trust)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if filelists {
+			err = repolib.DownloadBinaryDatabases(clear_version, repo_layout,
+				trust, "filelists")
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		err = repolib.DownloadBundles(clear_version, repo_layout, trust)
+		if err != nil {
+			log.Fatal(err)
+		}
+		versions = append(versions, clear_version)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Serving %v on http://%s\n", versions, listener.Addr())
+
+	log.Fatal(http.Serve(listener, server.New(versions, repo_layout, trust)))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+func main() {
+	var version_spec string
+	flag.StringVar(&version_spec, "clear_version", "installed",
+		common.VersionHelp)
+
+	var base_repo_url string
+	flag.StringVar(&base_repo_url, "repo_url",
+		"https://cdn.download.clearlinux.org",
+		"Base URL downloading releases")
+
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var arch string
+	flag.StringVar(&arch, "arch", "",
+		"Architecture of the binary packages, overriding the layout")
+
+	var image_name string
+	flag.StringVar(&image_name, "image", "",
+		"Size the bundles of this image")
+
+	var manifests bool
+	flag.BoolVar(&manifests, "manifests", true,
+		"Download the bundle manifests for the size of the bundle files")
+
+	var print_json bool
+	flag.BoolVar(&print_json, "json", false, "Print the sizes as JSON")
+
+	var repo_keyring string
+	flag.StringVar(&repo_keyring, "repo_keyring", "",
+		"Only trust repo metadata signed by a key in this keyring")
+
+	var swupd_cert string
+	flag.StringVar(&swupd_cert, "swupd_cert", "",
+		"Only trust bundle metadata signed with this certificate")
+
+	flag.Usage = func() {
+		fmt.Printf("USAGE for %s [options] bundles...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	downloader.Progress = os.Stderr
+
+	bundles := flag.Args()
+	if len(bundles) == 0 && image_name == "" {
+		flag.Usage()
+		os.Exit(-1)
+	}
+
+	repo_layout, err := common.LoadLayout(repo_layout_path, base_repo_url, arch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	clear_version, err := common.ResolveVersion(version_spec, repo_layout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	trust, err := repolib.LoadTrust(repo_keyring, swupd_cert)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = repolib.DownloadRepo(clear_version, repo_layout, trust)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if image_name != "" {
+		image, err := repolib.GetImage(clear_version, image_name, repo_layout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bundles = append(bundles, image.Bundles()...)
+	}
+
+	report, err := repolib.GetBundleSizes(clear_version, bundles,
+		repo_layout, trust, manifests)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	if print_json {
+		out, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	content := func(size int64) string {
+		if !manifests {
+			return "-"
+		}
+		return humanize.Bytes(uint64(size))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Bundle\tPackages\tShared\tDownload\tInstalled\tContent")
+	for _, b := range report.Bundles {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", b.Name, len(b.Packages),
+			len(b.Shared), humanize.Bytes(uint64(b.PackageSize)),
+			humanize.Bytes(uint64(b.InstalledSize)), content(b.ContentSize))
+	}
+	fmt.Fprintf(w, "Total\t%d\t%d\t%s\t%s\t%s\n", report.Packages,
+		len(report.Shared), humanize.Bytes(uint64(report.PackageSize)),
+		humanize.Bytes(uint64(report.InstalledSize)),
+		content(report.ContentSize))
+	w.Flush()
+
+	if len(report.Shared) == 0 {
+		return
+	}
+	fmt.Println("\nPackages shared between bundles:")
+	for _, p := range report.Shared {
+		fmt.Printf("  %s (%s installed): %s\n", p.Name,
+			humanize.Bytes(uint64(p.Installed)), strings.Join(p.Bundles, ", "))
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+func main() {
+	var version_spec string
+	flag.StringVar(&version_spec, "clear_version", "installed",
+		common.VersionHelp)
+
+	var base_repo_url string
+	flag.StringVar(&base_repo_url, "repo_url",
+		"https://cdn.download.clearlinux.org",
+		"Base URL downloading releases")
+
+	var repo_layout_path string
+	flag.StringVar(&repo_layout_path, "repo_layout", "", common.LayoutHelp)
+
+	var arch string
+	flag.StringVar(&arch, "arch", "",
+		"Architecture of the binary packages, overriding the layout")
+
+	var filelists bool
+	flag.BoolVar(&filelists, "filelists", true,
+		"Download the complete file lists to look up paths")
+
+	var print_json bool
+	flag.BoolVar(&print_json, "json", false,
+		"Print the providers of each capability as JSON")
+
+	var repo_keyring string
+	flag.StringVar(&repo_keyring, "repo_keyring", "",
+		"Only trust repo metadata signed by a key in this keyring")
+
+	flag.Usage = func() {
+		fmt.Printf("USAGE for %s [options] capabilities or paths...\n",
+			os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	downloader.Progress = os.Stderr
+
+	args := flag.Args()
+
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		log.Fatal()
+	}
+	if info.Mode()&os.ModeNamedPipe != 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			new_args := strings.Split(scanner.Text(), " ")
+			args = append(args, new_args...)
+		}
+	}
+
+	var queries []string
+	paths := false
+	for _, arg := range args {
+		if arg != "" {
+			queries = append(queries, arg)
+			paths = paths || strings.HasPrefix(arg, "/")
+		}
+	}
+	if len(queries) == 0 {
+		flag.Usage()
+		os.Exit(-1)
+	}
+
+	repo_layout, err := common.LoadLayout(repo_layout_path, base_repo_url, arch)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	clear_version, err := common.ResolveVersion(version_spec, repo_layout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	trust, err := repolib.LoadTrust(repo_keyring, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = repolib.DownloadRepo(clear_version, repo_layout, trust)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if paths && filelists {
+		err = repolib.DownloadBinaryDatabases(clear_version, repo_layout,
+			trust, "filelists")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	providers, err := repolib.WhatProvides(clear_version, queries)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	missing := 0
+	packages := make(map[string]bool)
+	for _, q := range queries {
+		if len(providers[q]) == 0 {
+			fmt.Fprintf(os.Stderr, "Nothing provides %s in version %d\n", q,
+				clear_version)
+			missing++
+		}
+		for _, p := range providers[q] {
+			packages[p] = true
+		}
+	}
+
+	if print_json {
+		out, err := json.MarshalIndent(providers, "", "    ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+	} else {
+		var names []string
+		for p := range packages {
+			names = append(names, p)
+		}
+		sort.Strings(names)
+		for _, p := range names {
+			fmt.Println(p)
+		}
+	}
+
+	if missing > 0 {
+		os.Exit(-1)
+	}
+}
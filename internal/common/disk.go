@@ -0,0 +1,27 @@
+package common
+
+import (
+	"os"
+	"syscall"
+)
+
+// DiskSpace returns the bytes available to unprivileged users on the
+// filesystem holding path, and the device it is on
+func DiskSpace(path string) (uint64, uint64, error) {
+	var fs syscall.Statfs_t
+	err := syscall.Statfs(path, &fs)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var dev uint64
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		dev = uint64(st.Dev)
+	}
+
+	return uint64(fs.Bavail) * uint64(fs.Bsize), dev, nil
+}
@@ -0,0 +1,153 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Layout tells where a distribution publishes its repos, update content
+// and image definitions. The templates may use {url}, {version}, {arch}
+// and, for FormatLatest, {format}. The debuginfo packages are looked for
+// in the binary repo when DebugRepo is empty.
+type Layout struct {
+	URL          string `json:"-"`
+	Arch         string `json:"arch"`
+	BinaryRepo   string `json:"binary_repo"`
+	SourceRepo   string `json:"source_repo"`
+	DebugRepo    string `json:"debug_repo"`
+	Update       string `json:"update"`
+	Images       string `json:"images"`
+	Releases     string `json:"releases"`
+	Latest       string `json:"latest"`
+	FormatLatest string `json:"format_latest"`
+}
+
+// LayoutHelp describes the layout file accepted by LoadLayout
+const LayoutHelp = "JSON file with the arch and URL templates of the " +
+	"repos, update content and image definitions"
+
+// DefaultLayout is the layout of the Clear Linux CDN, served from url
+func DefaultLayout(url string) *Layout {
+	return &Layout{
+		URL:          strings.TrimSuffix(url, "/"),
+		Arch:         "x86_64",
+		BinaryRepo:   "{url}/releases/{version}/clear/{arch}/os",
+		SourceRepo:   "{url}/releases/{version}/clear/source/SRPMS",
+		DebugRepo:    "{url}/releases/{version}/clear/{arch}/debug",
+		Update:       "{url}/update/{version}",
+		Images:       "{url}/releases/{version}/clear/config/image",
+		Releases:     "{url}/releases",
+		Latest:       "{url}/current/latest",
+		FormatLatest: "{url}/update/version/format{format}/latest",
+	}
+}
+
+// LoadLayout reads the layout file at path over the default layout, an
+// empty path keeping the defaults. A non empty arch overrides the one of
+// the file.
+func LoadLayout(path string, url string, arch string) (*Layout, error) {
+	if path == "" {
+		return ParseLayout(nil, url, arch)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := ParseLayout(content, url, arch)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", path, err))
+	}
+	return layout, nil
+}
+
+// ParseLayout reads a JSON layout over the default one like LoadLayout
+func ParseLayout(content []byte, url string, arch string) (*Layout, error) {
+	layout := DefaultLayout(url)
+	if len(content) > 0 {
+		err := json.Unmarshal(content, layout)
+		if err != nil {
+			return nil, errors.New("Corrupt layout: " + err.Error())
+		}
+	}
+	if arch != "" {
+		layout.Arch = arch
+	}
+
+	for name, t := range map[string]string{
+		"arch":          layout.Arch,
+		"binary_repo":   layout.BinaryRepo,
+		"source_repo":   layout.SourceRepo,
+		"update":        layout.Update,
+		"images":        layout.Images,
+		"releases":      layout.Releases,
+		"latest":        layout.Latest,
+		"format_latest": layout.FormatLatest,
+	} {
+		if t == "" {
+			return nil, errors.New("Layout has no " + name)
+		}
+	}
+	return layout, nil
+}
+
+func (l *Layout) expand(template string, version int) string {
+	return strings.NewReplacer(
+		"{url}", l.URL,
+		"{arch}", l.Arch,
+		"{version}", fmt.Sprintf("%d", version),
+	).Replace(template)
+}
+
+// BinaryRepoURL is the URL of the binary package repo of version
+func (l *Layout) BinaryRepoURL(version int) string {
+	return l.expand(l.BinaryRepo, version)
+}
+
+// SourceRepoURL is the URL of the source package repo of version
+func (l *Layout) SourceRepoURL(version int) string {
+	return l.expand(l.SourceRepo, version)
+}
+
+// DebugRepoURL is the URL of the repo holding the debuginfo packages of
+// version
+func (l *Layout) DebugRepoURL(version int) string {
+	if l.DebugRepo == "" {
+		return l.BinaryRepoURL(version)
+	}
+	return l.expand(l.DebugRepo, version)
+}
+
+// SrpmURL is the URL of a source rpm of version
+func (l *Layout) SrpmURL(version int, srpm string) string {
+	return l.SourceRepoURL(version) + "/" + srpm
+}
+
+// UpdateURL is the URL of the swupd content of version
+func (l *Layout) UpdateURL(version int) string {
+	return l.expand(l.Update, version)
+}
+
+// ImagesURL is the URL of the directory holding the image definitions of
+// version
+func (l *Layout) ImagesURL(version int) string {
+	return l.expand(l.Images, version)
+}
+
+// ReleasesURL is the URL of the directory listing every release
+func (l *Layout) ReleasesURL() string {
+	return l.expand(l.Releases, 0)
+}
+
+// LatestURL is the URL of the file naming the latest release
+func (l *Layout) LatestURL() string {
+	return l.expand(l.Latest, 0)
+}
+
+// FormatLatestURL is the URL of the file naming the last release of format
+func (l *Layout) FormatLatestURL(format int) string {
+	return strings.Replace(l.expand(l.FormatLatest, 0), "{format}",
+		fmt.Sprintf("%d", format), -1)
+}
@@ -0,0 +1,136 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VersionHelp describes the values accepted by ResolveVersion
+const VersionHelp = "Clear Linux version: a number, installed, latest, " +
+	"latest-N (N releases before latest) or formatN (last release in " +
+	"format N)"
+
+var relativeVersionRe = regexp.MustCompile(`^latest-(\d+)$`)
+var formatVersionRe = regexp.MustCompile(`^format(\d+)$`)
+var releaseRe = regexp.MustCompile(`href="(?:\./)?(\d+)/"`)
+
+func fetchVersion(url string) (int, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New(fmt.Sprintf("Unable to fetch %s: %s", url,
+			resp.Status))
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(body)))
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("No version found at %s", url))
+	}
+	return version, nil
+}
+
+// GetLatestVersion asks the update server for its most recent release
+func GetLatestVersion(layout *Layout) (int, error) {
+	return fetchVersion(layout.LatestURL())
+}
+
+// GetFormatVersion asks the update server for the last release in format
+func GetFormatVersion(format int, layout *Layout) (int, error) {
+	return fetchVersion(layout.FormatLatestURL(format))
+}
+
+// GetReleases lists the releases published on the server, oldest first
+func GetReleases(layout *Layout) ([]int, error) {
+	url := layout.ReleasesURL() + "/"
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Unable to list releases at %s: %s",
+			url, resp.Status))
+	}
+
+	found := make(map[int]bool)
+	for _, m := range releaseRe.FindAllStringSubmatch(string(body), -1) {
+		v, err := strconv.Atoi(m[1])
+		if err == nil {
+			found[v] = true
+		}
+	}
+
+	var releases []int
+	for v := range found {
+		releases = append(releases, v)
+	}
+	sort.Ints(releases)
+	return releases, nil
+}
+
+// ResolveVersion turns a version given on the command line into a release
+// number, asking the update server of the layout where needed
+func ResolveVersion(spec string, layout *Layout) (int, error) {
+	switch spec {
+	case "", "installed":
+		version, err := GetInstalledVersion()
+		if err != nil {
+			return 0, errors.New("A version must be specified when not " +
+				"running on a Clear Linux instance!")
+		}
+		return version, nil
+	case "latest":
+		return GetLatestVersion(layout)
+	}
+
+	if m := relativeVersionRe.FindStringSubmatch(spec); m != nil {
+		back, _ := strconv.Atoi(m[1])
+		latest, err := GetLatestVersion(layout)
+		if err != nil {
+			return 0, err
+		}
+		releases, err := GetReleases(layout)
+		if err != nil {
+			return 0, err
+		}
+
+		// Releases newer than the advertised latest are not out yet
+		i := sort.SearchInts(releases, latest+1) - 1 - back
+		if i < 0 {
+			return 0, errors.New(fmt.Sprintf(
+				"There are no %d releases before %d", back, latest))
+		}
+		return releases[i], nil
+	}
+
+	if m := formatVersionRe.FindStringSubmatch(spec); m != nil {
+		format, _ := strconv.Atoi(m[1])
+		return GetFormatVersion(format, layout)
+	}
+
+	version, err := strconv.Atoi(spec)
+	if err != nil || version < 0 {
+		return 0, errors.New(fmt.Sprintf("Invalid version %s, expected %s",
+			spec, strings.TrimPrefix(VersionHelp, "Clear Linux version: ")))
+	}
+	return version, nil
+}
@@ -12,6 +12,10 @@ import (
 	"strings"
 )
 
+// Progress receives the download progress, commands printing data on stdout
+// send it to stderr instead
+var Progress io.Writer = os.Stdout
+
 type WriteCounter struct {
 	Total uint64
 	Name  string
@@ -24,11 +28,23 @@ func (wc *WriteCounter) Write(p []byte) (int, error) {
 	return n, nil
 }
 func (wc WriteCounter) PrintProgress() {
-	fmt.Printf("\r%s", strings.Repeat(" ", 80))
-	fmt.Printf("\rDownloading %s... %s complete", wc.Name,
+	fmt.Fprintf(Progress, "\r%s", strings.Repeat(" ", 80))
+	fmt.Fprintf(Progress, "\rDownloading %s... %s complete", wc.Name,
 		humanize.Bytes(wc.Total))
 }
 
+// StatusError is returned for downloads the server did not answer with
+// the file
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Unable to fetch %s: %s", e.URL, e.Status)
+}
+
 func ChecksumFile(filepath string) (string, error) {
 	f, err := os.Open(filepath)
 	if err != nil {
@@ -45,6 +61,35 @@ func ChecksumFile(filepath string) (string, error) {
 }
 
 func DownloadFile(filepath, url, checksum, extra string) error {
+	return DownloadVerified(filepath, url, func(tmp string) error {
+		if checksum == "" {
+			return nil
+		}
+		actual_checksum, err := ChecksumFile(tmp)
+		if err != nil {
+			return err
+		}
+		if actual_checksum != checksum {
+			return errors.New("Failed download checksum!")
+		}
+		return nil
+	}, extra)
+}
+
+// DownloadVerified is DownloadFile with verify deciding whether the
+// download, given its temporary path, is kept
+func DownloadVerified(filepath, url string, verify func(string) error, extra string) error {
+	if _, err := os.Stat(filepath); !os.IsNotExist(err) {
+		return nil
+	}
+
+	// Another process may be downloading the same file, wait for it and
+	// check again once it is done
+	lock, err := Lock(filepath)
+	if err != nil {
+		return err
+	}
+	defer Unlock(lock)
 	if _, err := os.Stat(filepath); !os.IsNotExist(err) {
 		return nil
 	}
@@ -63,6 +108,12 @@ func DownloadFile(filepath, url, checksum, extra string) error {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		out.Close()
+		os.Remove(tmp)
+		return &StatusError{URL: url, StatusCode: resp.StatusCode,
+			Status: resp.Status}
+	}
 
 	counter := &WriteCounter{Name: extra + filepath}
 	_, err = io.Copy(out, io.TeeReader(resp.Body, counter))
@@ -71,17 +122,12 @@ func DownloadFile(filepath, url, checksum, extra string) error {
 	}
 
 	// Clear the progress output
-	fmt.Print("\n")
+	fmt.Fprint(Progress, "\n")
 
-	if checksum != "" {
-		actual_checksum, err := ChecksumFile(tmp)
-		if err != nil {
-			return err
-		}
-		if actual_checksum != checksum {
-			os.Remove(tmp)
-			return errors.New("Failed download checksum!")
-		}
+	err = verify(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
 	}
 
 	// download was successful so rename temporary file
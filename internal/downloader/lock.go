@@ -0,0 +1,66 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+var ErrLocked = errors.New("Locked by another process")
+
+// Lock takes an exclusive lock on path+".lock", waiting for any other
+// process holding it. The lock file is removed again by Unlock.
+func Lock(path string) (*os.File, error) {
+	return lock(path, true)
+}
+
+// TryLock takes the same lock as Lock, but fails with ErrLocked instead of
+// waiting when another process holds it
+func TryLock(path string) (*os.File, error) {
+	return lock(path, false)
+}
+
+func lock(path string, wait bool) (*os.File, error) {
+	lockpath := path + ".lock"
+	for {
+		f, err := os.OpenFile(lockpath, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == syscall.EWOULDBLOCK && !wait {
+			f.Close()
+			return nil, ErrLocked
+		} else if err == syscall.EWOULDBLOCK {
+			fmt.Fprintf(Progress, "Waiting for another process using %s\n", path)
+			err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		// The previous holder removes the file on unlock, in which case
+		// we locked a file nobody else will see and have to start over
+		held, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		current, err := os.Stat(lockpath)
+		if err == nil && os.SameFile(held, current) {
+			return f, nil
+		}
+		f.Close()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+func Unlock(f *os.File) {
+	os.Remove(f.Name())
+	f.Close()
+}
@@ -0,0 +1,178 @@
+package downloader
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Mirror serves the releases/ and update/ paths of an upstream CDN from
+// a local cache, downloading what is missing on the first request. Only
+// the content of numbered releases is cached, the files naming the latest
+// release and directory listings change and are passed through.
+type Mirror struct {
+	Upstream string
+	Dir      string
+
+	// Verify checks the file holding the content of a path, an error
+	// refusing it
+	Verify func(path string, file string) error
+
+	// Local returns the files the tools may already keep the content of a
+	// path in. Such files are served as hits once they pass the same
+	// checks as downloads.
+	Local func(path string) []string
+
+	// Log receives a line for every request
+	Log *log.Logger
+}
+
+// NewMirror creates a mirror of upstream cached in dir, logging to stderr
+func NewMirror(upstream string, dir string) *Mirror {
+	return &Mirror{
+		Upstream: strings.TrimSuffix(upstream, "/"),
+		Dir:      dir,
+		Log:      log.New(os.Stderr, "", log.LstdFlags),
+	}
+}
+
+// cacheable tells whether the path belongs to a numbered release, whose
+// content never changes once published
+func cacheable(parts []string) bool {
+	if len(parts) < 3 || (parts[0] != "releases" && parts[0] != "update") {
+		return false
+	}
+	_, err := strconv.Atoi(parts[1])
+	return err == nil
+}
+
+func (m *Mirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := path.Clean("/" + r.URL.Path)
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+
+	served := "pass"
+	status := http.StatusOK
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		status = http.StatusMethodNotAllowed
+		http.Error(w, "Only GET requests are supported", status)
+	} else if !m.allowed(parts) {
+		status = http.StatusNotFound
+		http.NotFound(w, r)
+	} else if cacheable(parts) && !strings.HasSuffix(r.URL.Path, "/") {
+		served, status = m.serveCached(w, r, p)
+	} else {
+		status = m.pass(w, r)
+	}
+
+	m.Log.Printf("%s %s %s %d", r.Method, r.URL.RequestURI(), served, status)
+}
+
+// allowed keeps requests to the CDN paths the tools and swupd use, and
+// away from the hidden metadata, partial downloads and locks of the cache
+func (m *Mirror) allowed(parts []string) bool {
+	switch parts[0] {
+	case "releases", "update", "current":
+	default:
+		return false
+	}
+	for _, part := range parts {
+		if strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".tmp") ||
+			strings.HasSuffix(part, ".lock") {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Mirror) serveCached(w http.ResponseWriter, r *http.Request, p string) (string, int) {
+	if local := m.local(p); local != "" {
+		http.ServeFile(w, r, local)
+		return "hit", http.StatusOK
+	}
+
+	target := filepath.Join(m.Dir, filepath.FromSlash(p))
+	info, err := os.Stat(target)
+	if err == nil && info.IsDir() {
+		// Directories of the cache only hold what was requested so far
+		return "pass", m.pass(w, r)
+	}
+
+	served := "hit"
+	if os.IsNotExist(err) {
+		served = "miss"
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err == nil {
+			err = m.fetch(target, p)
+		}
+	}
+	if err != nil {
+		status := http.StatusBadGateway
+		if e, ok := err.(*StatusError); ok && e.StatusCode == http.StatusNotFound {
+			status = http.StatusNotFound
+		}
+		m.Log.Printf("%s: %s", p, err)
+		http.Error(w, err.Error(), status)
+		return served, status
+	}
+
+	http.ServeFile(w, r, target)
+	return served, http.StatusOK
+}
+
+func (m *Mirror) verify(p string, file string) error {
+	if m.Verify == nil {
+		return nil
+	}
+	return m.Verify(p, file)
+}
+
+// local returns the file of the tools holding the content of the path,
+// or "" when there is none to be trusted
+func (m *Mirror) local(p string) string {
+	if m.Local == nil {
+		return ""
+	}
+	for _, file := range m.Local(p) {
+		info, err := os.Stat(file)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if m.verify(p, file) == nil {
+			return file
+		}
+	}
+	return ""
+}
+
+func (m *Mirror) fetch(target string, p string) error {
+	return DownloadVerified(target, m.Upstream+p, func(tmp string) error {
+		return m.verify(p, tmp)
+	}, "")
+}
+
+// pass forwards the request upstream without caching the answer
+func (m *Mirror) pass(w http.ResponseWriter, r *http.Request) int {
+	resp, err := http.Get(m.Upstream + r.URL.RequestURI())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return http.StatusBadGateway
+	}
+	defer resp.Body.Close()
+
+	for _, header := range []string{"Content-Type", "Content-Length",
+		"Last-Modified"} {
+		if v := resp.Header.Get(header); v != "" {
+			w.Header().Set(header, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if r.Method != http.MethodHead {
+		io.Copy(w, resp.Body)
+	}
+	return resp.StatusCode
+}
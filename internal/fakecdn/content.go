@@ -0,0 +1,256 @@
+package fakecdn
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/intel/clear-linux-dissector/internal/swupd"
+	"github.com/ulikunitz/xz"
+)
+
+type bundleHeader struct {
+	Title        string
+	Description  string
+	Status       string
+	Capabilities string
+	Maintainer   string
+}
+
+type bundleInfo struct {
+	Name             string
+	Filename         string
+	Header           bundleHeader
+	DirectIncludes   []string
+	OptionalIncludes []string
+	DirectPackages   map[string]bool
+	AllPackages      map[string]bool
+	Files            map[string]bool
+}
+
+type imagePartition struct {
+	Disk      string `json:"disk"`
+	Partition int    `json:"partition"`
+	Size      string `json:"size,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Mount     string `json:"mount,omitempty"`
+}
+
+type imageConfig struct {
+	DestinationType      string
+	PartitionLayout      []imagePartition
+	FilesystemTypes      []imagePartition
+	PartitionMountPoints []imagePartition
+	Version              string
+	Bundles              []string
+	LegacyBios           bool
+	PostNonChroot        []string
+	Language             string
+}
+
+// closure resolves the full set of packages needed by the given ones
+func (r *Release) closure(names []string) map[string]bool {
+	providers := make(map[string]string)
+	requires := make(map[string][]string)
+	for _, p := range r.Packages {
+		providers[p.Name] = p.Name
+		for _, provide := range p.Provides {
+			providers[provide] = p.Name
+		}
+		requires[p.Name] = p.Requires
+	}
+
+	all := make(map[string]bool)
+	pending := append([]string{}, names...)
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if all[name] {
+			continue
+		}
+		all[name] = true
+		for _, req := range requires[name] {
+			if provider, ok := providers[req]; ok {
+				pending = append(pending, provider)
+			}
+		}
+	}
+	return all
+}
+
+func manifest(version int, entries []string, contentsize int) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "MANIFEST\t30\nversion:\t%d\nprevious:\t0\n"+
+		"filecount:\t%d\ntimestamp:\t1500000000\ncontentsize:\t%d\n\n",
+		version, len(entries), contentsize)
+	sort.Strings(entries)
+	for _, e := range entries {
+		b.WriteString(e + "\n")
+	}
+	return b.Bytes()
+}
+
+func (cdn *CDN) generateBundles(r *Release) error {
+	var pack bytes.Buffer
+	xzw, err := xz.NewWriter(&pack)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(xzw)
+
+	files := make(map[string]string)
+	for _, p := range r.Packages {
+		for f, content := range p.Files {
+			files["/"+f] = content
+		}
+	}
+
+	update := fmt.Sprintf("update/%d/", r.Version)
+	entries := map[string][]byte{
+		"Manifest-os-core-update-index-delta-from-0": []byte("MANIFEST\t30\n"),
+	}
+	var index, mom []string
+	indexSize := 0
+	for _, b := range r.Bundles {
+		info := bundleInfo{
+			Name:     b.Name,
+			Filename: b.Name,
+			Header: bundleHeader{
+				Title:       b.Name,
+				Description: "The " + b.Name + " bundle",
+				Status:      "Active",
+				Maintainer:  "Clear Linux",
+			},
+			DirectIncludes:   b.Includes,
+			OptionalIncludes: []string{},
+			DirectPackages:   make(map[string]bool),
+			AllPackages:      r.closure(b.Packages),
+			Files:            make(map[string]bool),
+		}
+		for _, p := range b.Packages {
+			info.DirectPackages[p] = true
+		}
+
+		var bundleFiles []string
+		contentsize := 0
+		for _, f := range b.Files {
+			info.Files[f] = true
+			hash := swupd.Hash([]byte(files[f]), 0100644, 0, 0)
+			bundleFiles = append(bundleFiles, fmt.Sprintf("F...\t%s\t%d\t%s",
+				hash, r.Version, f))
+			contentsize += len(files[f])
+		}
+		content := manifest(r.Version, bundleFiles, contentsize)
+		err = cdn.writeFile(update+"Manifest."+b.Name, content)
+		if err != nil {
+			return err
+		}
+		mom = append(mom, fmt.Sprintf("M...\t%s\t%d\t%s",
+			swupd.Hash(content, 0100644, 0, 0), r.Version, b.Name))
+
+		content, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		hash := swupd.Hash(content, 0100644, 0, 0)
+		entries["staged/"+hash] = content
+		index = append(index, fmt.Sprintf("F...\t%s\t%d\t/usr/share/clear/allbundles/%s",
+			hash, r.Version, b.Name))
+		indexSize += len(content)
+	}
+
+	content := manifest(r.Version, index, indexSize)
+	err = cdn.writeFile(update+"Manifest.os-core-update-index", content)
+	if err != nil {
+		return err
+	}
+	mom = append(mom, fmt.Sprintf("M...\t%s\t%d\tos-core-update-index",
+		swupd.Hash(content, 0100644, 0, 0), r.Version))
+
+	content = manifest(r.Version, mom, 0)
+	err = cdn.writeFile(update+"Manifest.MoM", content)
+	if err != nil {
+		return err
+	}
+	if r.SwupdSigner != nil {
+		signature, err := r.SwupdSigner.Sign(content)
+		if err != nil {
+			return err
+		}
+		err = cdn.writeFile(update+"Manifest.MoM.sig", signature)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tw.WriteHeader(&tar.Header{Name: "staged", Mode: 0755,
+		Typeflag: tar.TypeDir})
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content := entries[name]
+		err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644,
+			Size: int64(len(content)), Typeflag: tar.TypeReg})
+		if err != nil {
+			return err
+		}
+		if _, err = tw.Write(content); err != nil {
+			return err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = xzw.Close(); err != nil {
+		return err
+	}
+
+	return cdn.writeFile(update+"pack-os-core-update-index-from-0.tar",
+		pack.Bytes())
+}
+
+func (cdn *CDN) generateImages(r *Release) error {
+	for _, image := range r.Images {
+		disk := image.Name + ".img"
+		config := imageConfig{
+			DestinationType: "virtual",
+			PartitionLayout: []imagePartition{
+				{Disk: disk, Partition: 1, Size: "512M", Type: "EFI"},
+				{Disk: disk, Partition: 2, Size: "4G", Type: "linux"},
+			},
+			FilesystemTypes: []imagePartition{
+				{Disk: disk, Partition: 1, Type: "vfat"},
+				{Disk: disk, Partition: 2, Type: "ext4"},
+			},
+			PartitionMountPoints: []imagePartition{
+				{Disk: disk, Partition: 1, Mount: "/boot"},
+				{Disk: disk, Partition: 2, Mount: "/"},
+			},
+			Version:       fmt.Sprintf("%d", r.Version),
+			Bundles:       image.Bundles,
+			LegacyBios:    true,
+			PostNonChroot: []string{"config/image/" + image.Name + "-post.sh"},
+			Language:      "en_US.UTF-8",
+		}
+
+		content, err := json.MarshalIndent(config, "", "    ")
+		if err != nil {
+			return err
+		}
+		err = cdn.writeFile(fmt.Sprintf(
+			"releases/%d/clear/config/image/%s-config.json",
+			r.Version, image.Name), content)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+// Package fakecdn serves small synthetic Clear Linux releases over
+// httptest so the tools can be tested without network access.
+package fakecdn
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+type Package struct {
+	Name     string
+	Version  string
+	Release  string
+	Source   string
+	Summary  string
+	License  string
+	Provides []string
+	Requires []string
+	Files    map[string]string
+}
+
+type ChangelogEntry struct {
+	Author string
+	Date   time.Time
+	Text   string
+}
+
+type Source struct {
+	Name      string
+	Version   string
+	Release   string
+	License   string
+	Files     map[string]string
+	Changelog []ChangelogEntry
+}
+
+type Bundle struct {
+	Name     string
+	Includes []string
+	Packages []string
+	Files    []string
+}
+
+type Image struct {
+	Name    string
+	Bundles []string
+}
+
+type Release struct {
+	Version  int
+	Format   int
+	Arch     string
+	Packages []Package
+	Sources  []Source
+	Bundles  []Bundle
+	Images   []Image
+
+	// DebugPackages are published in the debug repo next to the os one
+	DebugPackages []Package
+
+	// Signer, when set, signs every SRPM and repomd.xml of the release
+	Signer *openpgp.Entity
+
+	// SwupdSigner, when set, signs Manifest.MoM of the release
+	SwupdSigner *CertSigner
+}
+
+type CDN struct {
+	*httptest.Server
+	Root     string
+	Releases []*Release
+}
+
+func (s Source) Filename() string {
+	return fmt.Sprintf("%s-%s-%s.src.rpm", s.Name, s.Version, s.Release)
+}
+
+func (p Package) Filename(arch string) string {
+	return fmt.Sprintf("%s-%s-%s.%s.rpm", p.Name, p.Version, p.Release, arch)
+}
+
+// BinaryArch is the architecture of the binary packages, x86_64 unless
+// Arch says otherwise
+func (r *Release) BinaryArch() string {
+	if r.Arch == "" {
+		return "x86_64"
+	}
+	return r.Arch
+}
+
+// New generates the content for every release into a temporary directory
+// and starts serving it. The last release is advertised as the latest one.
+func New(releases ...*Release) (*CDN, error) {
+	root, err := ioutil.TempDir("", "fakecdn")
+	if err != nil {
+		return nil, err
+	}
+
+	cdn := &CDN{Root: root, Releases: releases}
+	for _, r := range releases {
+		err = cdn.generate(r)
+		if err != nil {
+			os.RemoveAll(root)
+			return nil, err
+		}
+	}
+
+	// The last release of each format is the one releases of the next
+	// format update from
+	formats := make(map[int]int)
+	for _, r := range releases {
+		if r.Version > formats[r.Format] {
+			formats[r.Format] = r.Version
+		}
+	}
+	for format, version := range formats {
+		err = cdn.writeFile(fmt.Sprintf("update/version/format%d/latest", format),
+			[]byte(fmt.Sprintf("%d\n", version)))
+		if err != nil {
+			os.RemoveAll(root)
+			return nil, err
+		}
+	}
+
+	if len(releases) > 0 {
+		latest := fmt.Sprintf("%d\n", releases[len(releases)-1].Version)
+		err = cdn.writeFile("current/latest", []byte(latest))
+		if err != nil {
+			os.RemoveAll(root)
+			return nil, err
+		}
+	}
+
+	cdn.Server = httptest.NewServer(http.FileServer(http.Dir(root)))
+	return cdn, nil
+}
+
+func (cdn *CDN) Close() {
+	cdn.Server.Close()
+	os.RemoveAll(cdn.Root)
+}
+
+func (cdn *CDN) writeFile(path string, content []byte) error {
+	target := filepath.Join(cdn.Root, path)
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(target, content, 0644)
+}
+
+func (cdn *CDN) generate(r *Release) error {
+	err := cdn.generateRepos(r)
+	if err != nil {
+		return err
+	}
+
+	err = cdn.generateBundles(r)
+	if err != nil {
+		return err
+	}
+
+	return cdn.generateImages(r)
+}
+
+// NewSigner creates a throwaway signing key for releases
+func NewSigner(name string) (*openpgp.Entity, error) {
+	return openpgp.NewEntity(name, "", name+"@example.com",
+		&packet.Config{RSABits: 1024})
+}
+
+// WritePublicKey stores the armored public key of the signer at path
+func WritePublicKey(signer *openpgp.Entity, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := armor.Encode(f, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return err
+	}
+	err = signer.Serialize(w)
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// Sample returns a small release modelled on a real Clear Linux one
+func Sample(version int) *Release {
+	return &Release{
+		Version: version,
+		Format:  30,
+		Sources: []Source{
+			{Name: "glibc", Version: "2.27", Release: "187", License: "LGPL-2.1",
+				Files: map[string]string{
+					"glibc.spec":           "Name: glibc\n",
+					"glibc-2.27.tar.xz":    "glibc sources\n",
+					"CVE-2018-11236.patch": "fix stack overflow\n",
+				},
+				Changelog: []ChangelogEntry{
+					{Author: "Clear Linux <dev@clearlinux.org>",
+						Date: time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC),
+						Text: "- Fix CVE-2018-11236 in realpath"},
+					{Author: "Clear Linux <dev@clearlinux.org>",
+						Date: time.Date(2018, 2, 2, 12, 0, 0, 0, time.UTC),
+						Text: "- Update to 2.27"},
+				}},
+			{Name: "bash", Version: "4.4", Release: "50", License: "GPL-3.0",
+				Files: map[string]string{
+					"bash.spec":       "Name: bash\n",
+					"bash-4.4.tar.gz": "bash sources\n",
+				}},
+			{Name: "ncurses", Version: "6.1", Release: "28", License: "MIT",
+				Files: map[string]string{
+					"ncurses.spec":       "Name: ncurses\n",
+					"ncurses-6.1.tar.gz": "ncurses sources\n",
+				}},
+			{Name: "zlib", Version: "1.2.11", Release: "30", License: "Zlib",
+				Files: map[string]string{
+					"zlib.spec":          "Name: zlib\n",
+					"zlib-1.2.11.tar.gz": "zlib sources\n",
+				},
+				Changelog: []ChangelogEntry{
+					{Author: "Clear Linux <dev@clearlinux.org>",
+						Date: time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC),
+						Text: "- Update to 1.2.11"},
+				}},
+			{Name: "linux-kvm", Version: "4.19.8", Release: "295", License: "GPL-2.0",
+				Files: map[string]string{
+					"linux-kvm.spec":      "Name: linux-kvm\n",
+					"linux-4.19.8.tar.xz": "linux sources\n",
+				}},
+		},
+		Packages: []Package{
+			{Name: "libc6", Version: "2.27", Release: "187", Source: "glibc",
+				Summary: "GNU C library", License: "LGPL-2.1",
+				Provides: []string{"libc.so.6()(64bit)"},
+				Files:    map[string]string{"usr/lib64/libc.so.6": "libc"}},
+			{Name: "glibc-bin", Version: "2.27", Release: "187", Source: "glibc",
+				Summary: "GNU C library utilities", License: "LGPL-2.1",
+				Requires: []string{"libc6", "/usr/bin/bash"},
+				Files:    map[string]string{"usr/bin/ldd": "ldd"}},
+			{Name: "bash", Version: "4.4", Release: "50", Source: "bash",
+				Summary: "The GNU Bourne Again shell", License: "GPL-3.0",
+				Requires: []string{"libc.so.6()(64bit)", "libncursesw.so.6()(64bit)"},
+				Files:    map[string]string{"usr/bin/bash": "bash"}},
+			{Name: "ncurses-lib", Version: "6.1", Release: "28", Source: "ncurses",
+				Summary: "Terminal handling library", License: "MIT",
+				Provides: []string{"libncursesw.so.6()(64bit)"},
+				Requires: []string{"libc6"},
+				Files:    map[string]string{"usr/lib64/libncursesw.so.6": "ncurses"}},
+			{Name: "zlib-lib", Version: "1.2.11", Release: "30", Source: "zlib",
+				Summary: "Compression library", License: "Zlib",
+				Provides: []string{"libz.so.1()(64bit)"},
+				Requires: []string{"libc6"},
+				Files:    map[string]string{"usr/lib64/libz.so.1": "zlib"}},
+			{Name: "linux-kvm", Version: "4.19.8", Release: "295", Source: "linux-kvm",
+				Summary: "The Linux kernel for KVM guests", License: "GPL-2.0",
+				Files: map[string]string{"usr/lib/kernel/org.clearlinux.kvm.4.19.8-295": "vmlinuz"}},
+		},
+		Bundles: []Bundle{
+			{Name: "os-core",
+				Packages: []string{"bash", "glibc-bin", "libc6", "ncurses-lib"},
+				Files:    []string{"/usr/bin/bash", "/usr/bin/ldd", "/usr/lib64/libc.so.6", "/usr/lib64/libncursesw.so.6"}},
+			{Name: "os-core-update", Includes: []string{"os-core"},
+				Packages: []string{"zlib-lib"},
+				Files:    []string{"/usr/lib64/libz.so.1"}},
+			{Name: "kernel-kvm",
+				Packages: []string{"linux-kvm"},
+				Files:    []string{"/usr/lib/kernel/org.clearlinux.kvm.4.19.8-295"}},
+		},
+		Images: []Image{
+			{Name: "kvm", Bundles: []string{"kernel-kvm", "os-core", "os-core-update"}},
+			{Name: "live-server", Bundles: []string{"os-core", "os-core-update"}},
+		},
+	}
+}
@@ -0,0 +1,373 @@
+package fakecdn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rustylynch/go-rpmutils"
+	"github.com/ulikunitz/xz"
+	"golang.org/x/crypto/openpgp"
+)
+
+const primarySchema = `
+CREATE TABLE db_info (dbversion INTEGER, checksum TEXT);
+CREATE TABLE packages (pkgKey INTEGER PRIMARY KEY, pkgId TEXT, name TEXT,
+	arch TEXT, version TEXT, epoch TEXT, release TEXT, summary TEXT,
+	description TEXT, url TEXT, time_file INTEGER, time_build INTEGER,
+	rpm_license TEXT, rpm_vendor TEXT, rpm_group TEXT, rpm_buildhost TEXT,
+	rpm_sourcerpm TEXT, rpm_header_start INTEGER, rpm_header_end INTEGER,
+	rpm_packager TEXT, size_package INTEGER, size_installed INTEGER,
+	size_archive INTEGER, location_href TEXT, location_base TEXT,
+	checksum_type TEXT);
+CREATE TABLE files (name TEXT, type TEXT, pkgKey INTEGER);
+CREATE TABLE requires (name TEXT, flags TEXT, epoch TEXT, version TEXT,
+	release TEXT, pkgKey INTEGER, pre BOOLEAN DEFAULT FALSE);
+CREATE TABLE provides (name TEXT, flags TEXT, epoch TEXT, version TEXT,
+	release TEXT, pkgKey INTEGER);
+CREATE TABLE conflicts (name TEXT, flags TEXT, epoch TEXT, version TEXT,
+	release TEXT, pkgKey INTEGER);
+CREATE TABLE obsoletes (name TEXT, flags TEXT, epoch TEXT, version TEXT,
+	release TEXT, pkgKey INTEGER);
+`
+
+const otherSchema = `
+CREATE TABLE db_info (dbversion INTEGER, checksum TEXT);
+CREATE TABLE packages (pkgKey INTEGER PRIMARY KEY, pkgId TEXT);
+CREATE TABLE changelog (pkgKey INTEGER, author TEXT, date INTEGER,
+	changelog TEXT);
+`
+
+const filelistsSchema = `
+CREATE TABLE db_info (dbversion INTEGER, checksum TEXT);
+CREATE TABLE packages (pkgKey INTEGER PRIMARY KEY, pkgId TEXT);
+CREATE TABLE filelist (pkgKey INTEGER, dirname TEXT, filenames TEXT,
+	filetypes TEXT);
+`
+
+type rpmRow struct {
+	name, version, release, arch string
+	summary, license, sourcerpm  string
+	href                         string
+	content                      []byte
+	installed                    int
+	provides, requires, files    []string
+	filelist                     []string
+	changelog                    []ChangelogEntry
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// primaryFiles picks the files createrepo lists in the primary database,
+// the rest are only in filelists
+func primaryFiles(files []string) []string {
+	var primary []string
+	for _, f := range files {
+		if strings.HasPrefix(f, "/etc/") || strings.Contains(f, "bin/") ||
+			f == "/usr/lib/sendmail" {
+			primary = append(primary, f)
+		}
+	}
+	return primary
+}
+
+func installedSize(files map[string]string) int {
+	size := 0
+	for _, content := range files {
+		size += len(content)
+	}
+	return size
+}
+
+func (cdn *CDN) generateRepos(r *Release) error {
+	base := fmt.Sprintf("releases/%d/clear", r.Version)
+
+	var sources []rpmRow
+	srpms := make(map[string]string)
+	changelogs := make(map[string][]ChangelogEntry)
+	for _, s := range r.Sources {
+		content, err := buildRpm(s.Name, s.Version, s.Release, "src", "",
+			s.License, s.Files)
+		if err != nil {
+			return err
+		}
+		if r.Signer != nil {
+			var signed bytes.Buffer
+			err = rpmutils.SignRpmFileIntoStream(&signed,
+				bytes.NewReader(content), r.Signer.PrivateKey, nil)
+			if err != nil {
+				return err
+			}
+			content = signed.Bytes()
+		}
+		err = cdn.writeFile(base+"/source/SRPMS/"+s.Filename(), content)
+		if err != nil {
+			return err
+		}
+		srpms[s.Name] = s.Filename()
+		changelogs[s.Name] = s.Changelog
+		var filelist []string
+		for f := range s.Files {
+			filelist = append(filelist, f)
+		}
+		sources = append(sources, rpmRow{
+			name: s.Name, version: s.Version, release: s.Release,
+			arch: "src", summary: s.Name, license: s.License,
+			href: s.Filename(), content: content,
+			installed: installedSize(s.Files), filelist: filelist,
+			changelog: s.Changelog,
+		})
+	}
+
+	arch := r.BinaryArch()
+	for repo, pkgs := range map[string][]Package{
+		"os":    r.Packages,
+		"debug": r.DebugPackages,
+	} {
+		var packages []rpmRow
+		for _, p := range pkgs {
+			content, err := buildRpm(p.Name, p.Version, p.Release, arch,
+				srpms[p.Source], p.License, p.Files)
+			if err != nil {
+				return err
+			}
+			err = cdn.writeFile(base+"/"+arch+"/"+repo+"/Packages/"+p.Filename(arch), content)
+			if err != nil {
+				return err
+			}
+			var files []string
+			for f := range p.Files {
+				files = append(files, "/"+f)
+			}
+			packages = append(packages, rpmRow{
+				name: p.Name, version: p.Version, release: p.Release,
+				arch: arch, summary: p.Summary, license: p.License,
+				sourcerpm: srpms[p.Source], href: "Packages/" + p.Filename(arch),
+				content: content, installed: installedSize(p.Files),
+				provides: append([]string{p.Name}, p.Provides...),
+				requires: p.Requires, files: primaryFiles(files), filelist: files,
+				changelog: changelogs[p.Source],
+			})
+		}
+
+		err := cdn.generateRepo(base+"/"+arch+"/"+repo, packages, r.Signer)
+		if err != nil {
+			return err
+		}
+	}
+	return cdn.generateRepo(base+"/source/SRPMS", sources, r.Signer)
+}
+
+// generateRepo writes the sqlite databases of the repo and the repomd.xml
+// listing them
+func (cdn *CDN) generateRepo(path string, rows []rpmRow, signer *openpgp.Entity) error {
+	tmp, err := ioutil.TempDir("", "fakecdn-repo")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	var data []string
+	for _, db := range []struct {
+		kind  string
+		write func(string, []rpmRow) error
+	}{
+		{"primary", writePrimary},
+		{"filelists", writeFilelists},
+		{"other", writeOther},
+	} {
+		dbpath := filepath.Join(tmp, db.kind+".sqlite")
+		err = db.write(dbpath, rows)
+		if err != nil {
+			return err
+		}
+
+		content, err := ioutil.ReadFile(dbpath)
+		if err != nil {
+			return err
+		}
+
+		var compressed bytes.Buffer
+		w, err := xz.NewWriter(&compressed)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(content); err != nil {
+			return err
+		}
+		if err = w.Close(); err != nil {
+			return err
+		}
+
+		cs := checksum(compressed.Bytes())
+		href := fmt.Sprintf("repodata/%s-%s.sqlite.xz", cs, db.kind)
+		err = cdn.writeFile(path+"/"+href, compressed.Bytes())
+		if err != nil {
+			return err
+		}
+
+		data = append(data, fmt.Sprintf(`  <data type="%s_db">
+    <checksum type="sha256">%s</checksum>
+    <open-checksum type="sha256">%s</open-checksum>
+    <location href="%s"/>
+    <size>%d</size>
+    <open-size>%d</open-size>
+    <database_version>10</database_version>
+  </data>
+`, db.kind, cs, checksum(content), href, compressed.Len(), len(content)))
+	}
+
+	repomd := `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo" xmlns:rpm="http://linux.duke.edu/metadata/rpm">
+  <revision>1</revision>
+` + strings.Join(data, "") + `</repomd>
+`
+
+	if signer != nil {
+		signature, err := armoredSignature(signer, []byte(repomd))
+		if err != nil {
+			return err
+		}
+		err = cdn.writeFile(path+"/repodata/repomd.xml.asc", signature)
+		if err != nil {
+			return err
+		}
+	}
+	return cdn.writeFile(path+"/repodata/repomd.xml", []byte(repomd))
+}
+
+func writePrimary(path string, rows []rpmRow) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(primarySchema)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		key := i + 1
+		_, err = db.Exec("INSERT INTO packages (pkgKey, pkgId, name, arch, "+
+			"version, epoch, release, summary, description, url, "+
+			"rpm_license, rpm_sourcerpm, size_package, size_installed, "+
+			"location_href, checksum_type) VALUES "+
+			"(?, ?, ?, ?, ?, '0', ?, ?, ?, ?, ?, ?, ?, ?, ?, 'sha256')",
+			key, checksum(row.content), row.name, row.arch, row.version,
+			row.release, row.summary, row.summary+".",
+			"https://example.com/"+row.name, row.license, row.sourcerpm,
+			len(row.content), row.installed, row.href)
+		if err != nil {
+			return err
+		}
+		for _, p := range row.provides {
+			_, err = db.Exec("INSERT INTO provides (name, pkgKey) "+
+				"VALUES (?, ?)", p, key)
+			if err != nil {
+				return err
+			}
+		}
+		for _, r := range row.requires {
+			_, err = db.Exec("INSERT INTO requires (name, pkgKey) "+
+				"VALUES (?, ?)", r, key)
+			if err != nil {
+				return err
+			}
+		}
+		for _, f := range row.files {
+			_, err = db.Exec("INSERT INTO files (name, type, pkgKey) "+
+				"VALUES (?, 'file', ?)", f, key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeOther(path string, rows []rpmRow) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(otherSchema)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		key := i + 1
+		_, err = db.Exec("INSERT INTO packages (pkgKey, pkgId) VALUES (?, ?)",
+			key, checksum(row.content))
+		if err != nil {
+			return err
+		}
+		for _, c := range row.changelog {
+			_, err = db.Exec("INSERT INTO changelog (pkgKey, author, date, "+
+				"changelog) VALUES (?, ?, ?, ?)", key, c.Author,
+				c.Date.Unix(), c.Text)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeFilelists(path string, rows []rpmRow) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(filelistsSchema)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		key := i + 1
+		_, err = db.Exec("INSERT INTO packages (pkgKey, pkgId) VALUES (?, ?)",
+			key, checksum(row.content))
+		if err != nil {
+			return err
+		}
+
+		// Files are stored per directory with the names joined by /
+		dirs := make(map[string][]string)
+		for _, f := range row.filelist {
+			dir, name := filepath.Split(f)
+			dir = strings.TrimSuffix(dir, "/")
+			dirs[dir] = append(dirs[dir], name)
+		}
+		for dir, names := range dirs {
+			sort.Strings(names)
+			_, err = db.Exec("INSERT INTO filelist (pkgKey, dirname, "+
+				"filenames, filetypes) VALUES (?, ?, ?, ?)", key, dir,
+				strings.Join(names, "/"), strings.Repeat("f", len(names)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
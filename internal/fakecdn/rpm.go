@@ -0,0 +1,173 @@
+package fakecdn
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	tagSigSize = 1000
+	tagSigMd5  = 1004
+	tagSigSha1 = 269
+
+	tagName              = 1000
+	tagVersion           = 1001
+	tagRelease           = 1002
+	tagSize              = 1009
+	tagLicense           = 1014
+	tagArch              = 1022
+	tagSourceRpm         = 1044
+	tagPayloadFormat     = 1124
+	tagPayloadCompressor = 1125
+
+	typeInt32  = 4
+	typeString = 6
+	typeBin    = 7
+)
+
+type headerEntry struct {
+	tag      int
+	dataType int
+	count    int
+	data     []byte
+}
+
+type header []headerEntry
+
+func (h *header) addString(tag int, value string) {
+	*h = append(*h, headerEntry{tag, typeString, 1, append([]byte(value), 0)})
+}
+
+func (h *header) addInt32(tag int, value int) {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(value))
+	*h = append(*h, headerEntry{tag, typeInt32, 1, data})
+}
+
+func (h *header) addBin(tag int, value []byte) {
+	*h = append(*h, headerEntry{tag, typeBin, len(value), value})
+}
+
+func (h header) encode(pad bool) []byte {
+	sort.Slice(h, func(i, j int) bool { return h[i].tag < h[j].tag })
+
+	var index, store bytes.Buffer
+	for _, e := range h {
+		if e.dataType == typeInt32 {
+			for store.Len()%4 != 0 {
+				store.WriteByte(0)
+			}
+		}
+		binary.Write(&index, binary.BigEndian, []int32{
+			int32(e.tag), int32(e.dataType),
+			int32(store.Len()), int32(e.count)})
+		store.Write(e.data)
+	}
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, []uint32{
+		0x8eade801, 0, uint32(len(h)), uint32(store.Len())})
+	out.Write(index.Bytes())
+	out.Write(store.Bytes())
+
+	// The signature header is padded to an 8 byte boundary
+	if pad {
+		for store.Len()%8 != 0 {
+			store.WriteByte(0)
+			out.WriteByte(0)
+		}
+	}
+	return out.Bytes()
+}
+
+func writeCpioEntry(w *bytes.Buffer, ino int, name string, mode int, content []byte) {
+	fmt.Fprintf(w, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+		ino, mode, 0, 0, 1, 0, len(content), 0, 0, 0, 0, len(name)+1, 0)
+	w.WriteString(name)
+	w.WriteByte(0)
+	for w.Len()%4 != 0 {
+		w.WriteByte(0)
+	}
+	w.Write(content)
+	for w.Len()%4 != 0 {
+		w.WriteByte(0)
+	}
+}
+
+func buildPayload(files map[string]string) ([]byte, int, error) {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var archive bytes.Buffer
+	size := 0
+	for i, name := range names {
+		writeCpioEntry(&archive, i+1, "./"+name, 0100644, []byte(files[name]))
+		size += len(files[name])
+	}
+	writeCpioEntry(&archive, 0, "TRAILER!!!", 0, nil)
+
+	var payload bytes.Buffer
+	gz := gzip.NewWriter(&payload)
+	if _, err := gz.Write(archive.Bytes()); err != nil {
+		return nil, 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, 0, err
+	}
+	return payload.Bytes(), size, nil
+}
+
+// buildRpm creates a minimal but well formed RPM holding the given files
+func buildRpm(name, version, release, arch, sourcerpm, license string, files map[string]string) ([]byte, error) {
+	payload, size, err := buildPayload(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var gen header
+	gen.addString(tagName, name)
+	gen.addString(tagVersion, version)
+	gen.addString(tagRelease, release)
+	gen.addInt32(tagSize, size)
+	gen.addString(tagLicense, license)
+	gen.addString(tagArch, arch)
+	if sourcerpm != "" {
+		gen.addString(tagSourceRpm, sourcerpm)
+	}
+	gen.addString(tagPayloadFormat, "cpio")
+	gen.addString(tagPayloadCompressor, "gzip")
+	genBlob := gen.encode(false)
+
+	digest := md5.New()
+	digest.Write(genBlob)
+	digest.Write(payload)
+
+	var sig header
+	sig.addInt32(tagSigSize, len(genBlob)+len(payload))
+	sig.addBin(tagSigMd5, digest.Sum(nil))
+	sig.addString(tagSigSha1, fmt.Sprintf("%x", sha1.Sum(genBlob)))
+
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+	if sourcerpm == "" {
+		binary.BigEndian.PutUint16(lead[6:], 1)
+	}
+	copy(lead[10:75], name+"-"+version+"-"+release)
+	binary.BigEndian.PutUint16(lead[76:], 1)
+	binary.BigEndian.PutUint16(lead[78:], 5)
+
+	var out bytes.Buffer
+	out.Write(lead)
+	out.Write(sig.encode(true))
+	out.Write(genBlob)
+	out.Write(payload)
+	return out.Bytes(), nil
+}
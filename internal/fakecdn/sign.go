@@ -0,0 +1,160 @@
+package fakecdn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"time"
+
+	"github.com/intel/clear-linux-dissector/internal/swupd"
+	"golang.org/x/crypto/openpgp"
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSA           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+)
+
+// CertSigner signs Manifest.MoM the way the swupd content is signed
+type CertSigner struct {
+	Certificate *x509.Certificate
+	Key         *rsa.PrivateKey
+}
+
+func NewCertSigner(name string) (*CertSigner, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CertSigner{Certificate: cert, Key: key}, nil
+}
+
+// WriteCertificate stores the PEM encoded certificate of the signer at path
+func (s *CertSigner) WriteCertificate(path string) error {
+	content := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+		Bytes: s.Certificate.Raw})
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+func asn1Set(values ...interface{}) (asn1.RawValue, error) {
+	var inner []byte
+	for _, v := range values {
+		b, err := asn1.Marshal(v)
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		inner = append(inner, b...)
+	}
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet,
+		IsCompound: true, Bytes: inner}, nil
+}
+
+func implicit(tag int, content []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag,
+		IsCompound: true, Bytes: content}
+}
+
+// Sign creates a detached DER encoded PKCS#7 signature with signed
+// attributes, as openssl smime -sign -binary -outform DER does
+func (s *CertSigner) Sign(content []byte) ([]byte, error) {
+	digest := sha256.Sum256(content)
+
+	contentType, err := asn1Set(oidData)
+	if err != nil {
+		return nil, err
+	}
+	messageDigest, err := asn1Set(digest[:])
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := asn1.MarshalWithParams([]swupd.Attribute{
+		{Type: oidContentType, Values: contentType},
+		{Type: oidMessageDigest, Values: messageDigest},
+	}, "set")
+	if err != nil {
+		return nil, err
+	}
+
+	attrsDigest := sha256.Sum256(attrs)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA256,
+		attrsDigest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// Inside SignerInfo the attributes carry an implicit [0] tag
+	var attrsValue asn1.RawValue
+	_, err = asn1.Unmarshal(attrs, &attrsValue)
+	if err != nil {
+		return nil, err
+	}
+
+	sd := swupd.SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		ContentInfo:      swupd.ContentInfo{ContentType: oidData},
+		Certificates:     implicit(0, s.Certificate.Raw),
+		SignerInfos: []swupd.SignerInfo{{
+			Version: 1,
+			IssuerAndSerial: swupd.IssuerAndSerial{
+				Issuer: asn1.RawValue{FullBytes: s.Certificate.RawIssuer},
+				Serial: s.Certificate.SerialNumber,
+			},
+			DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			AuthenticatedAttributes:   implicit(0, attrsValue.Bytes),
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSA},
+			EncryptedDigest:           signature,
+		}},
+	}
+	sdBytes, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{oidSignedData, implicit(0, sdBytes)})
+}
+
+func armoredSignature(signer *openpgp.Entity, content []byte) ([]byte, error) {
+	var signature bytes.Buffer
+	err := openpgp.ArmoredDetachSign(&signature, signer,
+		bytes.NewReader(content), nil)
+	if err != nil {
+		return nil, err
+	}
+	return signature.Bytes(), nil
+}
@@ -0,0 +1,175 @@
+package graph
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Formats lists the formats Write supports
+var Formats = []string{"dot", "graphml", "json"}
+
+// ID identifies the node in the exported graphs, the same name can be used
+// by a bundle, a package and a source package
+func (n Node) ID() string {
+	return n.Kind + ":" + n.Name
+}
+
+var dotShapes = map[string]string{
+	Bundle:  "box",
+	Package: "ellipse",
+	Srpm:    "note",
+}
+
+func dotQuote(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	return "\"" + strings.Replace(s, "\"", "\\\"", -1) + "\""
+}
+
+func WriteDOT(w io.Writer, g *Graph) error {
+	_, err := fmt.Fprintln(w, "digraph dependencies {")
+	if err != nil {
+		return err
+	}
+	for _, n := range g.Nodes() {
+		_, err = fmt.Fprintf(w, "\t%s [label=%s, shape=%s];\n",
+			dotQuote(n.ID()), dotQuote(n.Name), dotShapes[n.Kind])
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range g.Edges() {
+		_, err = fmt.Fprintf(w, "\t%s -> %s [label=%s];\n", dotQuote(e.From.ID()),
+			dotQuote(e.To.ID()), dotQuote(e.Label()))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintln(w, "}")
+	return err
+}
+
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphmlKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphmlNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphmlData `xml:"data"`
+}
+
+type graphml struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphmlKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphmlNode `xml:"node"`
+		Edges       []graphmlEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+func WriteGraphML(w io.Writer, g *Graph) error {
+	doc := graphml{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphmlKey{
+			{"kind", "node", "kind", "string"},
+			{"name", "node", "name", "string"},
+			{"label", "edge", "label", "string"},
+		},
+	}
+	doc.Graph.ID = "dependencies"
+	doc.Graph.EdgeDefault = "directed"
+	for _, n := range g.Nodes() {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphmlNode{
+			ID:   n.ID(),
+			Data: []graphmlData{{"kind", n.Kind}, {"name", n.Name}},
+		})
+	}
+	for _, e := range g.Edges() {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphmlEdge{
+			Source: e.From.ID(),
+			Target: e.To.ID(),
+			Data:   []graphmlData{{"label", e.Label()}},
+		})
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(doc)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+type jsonNode struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+type jsonEdge struct {
+	From   string   `json:"from"`
+	To     string   `json:"to"`
+	Labels []string `json:"labels"`
+}
+
+// WriteJSON writes the graph as lists of nodes and edges
+func WriteJSON(w io.Writer, g *Graph) error {
+	doc := struct {
+		Nodes []jsonNode `json:"nodes"`
+		Edges []jsonEdge `json:"edges"`
+	}{[]jsonNode{}, []jsonEdge{}}
+	for _, n := range g.Nodes() {
+		doc.Nodes = append(doc.Nodes, jsonNode{n.ID(), n.Kind, n.Name})
+	}
+	for _, e := range g.Edges() {
+		doc.Edges = append(doc.Edges, jsonEdge{e.From.ID(), e.To.ID(),
+			e.Labels})
+	}
+
+	out, err := json.MarshalIndent(doc, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(out))
+	return err
+}
+
+// Write exports the graph in one of the Formats
+func Write(w io.Writer, g *Graph, format string) error {
+	switch format {
+	case "dot":
+		return WriteDOT(w, g)
+	case "graphml":
+		return WriteGraphML(w, g)
+	case "json":
+		return WriteJSON(w, g)
+	}
+	return errors.New(fmt.Sprintf("Unknown graph format %s, use one of %s",
+		format, strings.Join(Formats, ", ")))
+}
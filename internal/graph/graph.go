@@ -0,0 +1,348 @@
+package graph
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	Bundle  = "bundle"
+	Package = "package"
+	Srpm    = "srpm"
+)
+
+// Kinds lists the node kinds in the order they sort
+var Kinds = []string{Bundle, Package, Srpm}
+
+type Node struct {
+	Kind string
+	Name string
+}
+
+// Edge from one node to another, with the reasons it exists
+type Edge struct {
+	From   Node
+	To     Node
+	Labels []string
+}
+
+func (e Edge) Label() string {
+	return strings.Join(e.Labels, ", ")
+}
+
+type Graph struct {
+	nodes map[Node]bool
+	out   map[Node]map[Node]*Edge
+}
+
+func New() *Graph {
+	return &Graph{
+		nodes: make(map[Node]bool),
+		out:   make(map[Node]map[Node]*Edge),
+	}
+}
+
+func (g *Graph) AddNode(n Node) {
+	g.nodes[n] = true
+}
+
+func (g *Graph) HasNode(n Node) bool {
+	return g.nodes[n]
+}
+
+// AddEdge connects from to to, adding label to the edge if they already
+// are
+func (g *Graph) AddEdge(from Node, to Node, label string) {
+	g.AddNode(from)
+	g.AddNode(to)
+	if g.out[from] == nil {
+		g.out[from] = make(map[Node]*Edge)
+	}
+	e, ok := g.out[from][to]
+	if !ok {
+		e = &Edge{From: from, To: to}
+		g.out[from][to] = e
+	}
+	for _, l := range e.Labels {
+		if l == label {
+			return
+		}
+	}
+	e.Labels = append(e.Labels, label)
+	sort.Strings(e.Labels)
+}
+
+func less(a Node, b Node) bool {
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	return a.Name < b.Name
+}
+
+// Nodes returns all nodes, bundles first, sorted by name
+func (g *Graph) Nodes() []Node {
+	var nodes []Node
+	for n := range g.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return less(nodes[i], nodes[j])
+	})
+	return nodes
+}
+
+// Names returns the names of all nodes, sorted like Nodes
+func (g *Graph) Names() []string {
+	var names []string
+	for _, n := range g.Nodes() {
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+// OutEdges returns the edges leaving n, sorted by their target
+func (g *Graph) OutEdges(n Node) []Edge {
+	var edges []Edge
+	for _, e := range g.out[n] {
+		edges = append(edges, *e)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		return less(edges[i].To, edges[j].To)
+	})
+	return edges
+}
+
+// Edges returns all edges, sorted by source and target
+func (g *Graph) Edges() []Edge {
+	var edges []Edge
+	for _, n := range g.Nodes() {
+		edges = append(edges, g.OutEdges(n)...)
+	}
+	return edges
+}
+
+// Reachable returns the nodes reachable from the sources, sources included
+func (g *Graph) Reachable(sources []Node) map[Node]bool {
+	seen := make(map[Node]bool)
+	queue := append([]Node{}, sources...)
+	for _, n := range sources {
+		seen[n] = true
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for to := range g.out[n] {
+			if !seen[to] {
+				seen[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+	return seen
+}
+
+// Within returns the part of the graph at most depth edges away from the
+// sources
+func (g *Graph) Within(sources []Node, depth int) *Graph {
+	dist := make(map[Node]int)
+	var queue []Node
+	for _, n := range sources {
+		if g.nodes[n] {
+			dist[n] = 0
+			queue = append(queue, n)
+		}
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if dist[n] == depth {
+			continue
+		}
+		for to := range g.out[n] {
+			if _, ok := dist[to]; !ok {
+				dist[to] = dist[n] + 1
+				queue = append(queue, to)
+			}
+		}
+	}
+
+	r := New()
+	for n := range dist {
+		r.AddNode(n)
+		for to, e := range g.out[n] {
+			if _, ok := dist[to]; ok {
+				for _, l := range e.Labels {
+					r.AddEdge(n, to, l)
+				}
+			}
+		}
+	}
+	return r
+}
+
+// Only returns the graph of the nodes of the given kinds. Where paths led
+// through nodes of other kinds their ends are connected directly, with an
+// edge labeled by the node the path left through.
+func (g *Graph) Only(kinds ...string) *Graph {
+	keep := make(map[string]bool)
+	for _, k := range kinds {
+		keep[k] = true
+	}
+
+	r := New()
+	for n := range g.nodes {
+		if !keep[n.Kind] {
+			continue
+		}
+		r.AddNode(n)
+		for _, e := range g.OutEdges(n) {
+			if keep[e.To.Kind] {
+				for _, l := range e.Labels {
+					r.AddEdge(e.From, e.To, l)
+				}
+				continue
+			}
+
+			via := "via " + e.To.Name
+			seen := map[Node]bool{e.To: true}
+			queue := []Node{e.To}
+			for len(queue) > 0 {
+				m := queue[0]
+				queue = queue[1:]
+				for to := range g.out[m] {
+					if seen[to] {
+						continue
+					}
+					seen[to] = true
+					if !keep[to.Kind] {
+						queue = append(queue, to)
+					} else if to != n {
+						r.AddEdge(n, to, via)
+					}
+				}
+			}
+		}
+	}
+	return r
+}
+
+// ShortestPath returns the edges of a shortest path from any of the
+// sources to target, nil if there is none. Ties are broken by name so the
+// result is stable.
+func (g *Graph) ShortestPath(sources []Node, target Node) []Edge {
+	sorted := append([]Node{}, sources...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+
+	prev := make(map[Node]Edge)
+	seen := make(map[Node]bool)
+	queue := sorted
+	for _, n := range sorted {
+		seen[n] = true
+	}
+	for len(queue) > 0 && !seen[target] {
+		n := queue[0]
+		queue = queue[1:]
+		for _, e := range g.OutEdges(n) {
+			if !seen[e.To] {
+				seen[e.To] = true
+				prev[e.To] = e
+				queue = append(queue, e.To)
+			}
+		}
+	}
+	if !seen[target] {
+		return nil
+	}
+
+	var path []Edge
+	for n := target; ; {
+		e, ok := prev[n]
+		if !ok {
+			break
+		}
+		path = append([]Edge{e}, path...)
+		n = e.From
+	}
+	return path
+}
+
+// distances returns how many edges each node is away from target, for
+// the nodes that can reach it
+func (g *Graph) distances(target Node) map[Node]int {
+	in := make(map[Node][]Node)
+	for from, edges := range g.out {
+		for to := range edges {
+			in[to] = append(in[to], from)
+		}
+	}
+
+	dist := map[Node]int{target: 0}
+	queue := []Node{target}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, from := range in[n] {
+			if _, ok := dist[from]; !ok {
+				dist[from] = dist[n] + 1
+				queue = append(queue, from)
+			}
+		}
+	}
+	return dist
+}
+
+// AllPaths returns up to limit paths without cycles from any of the
+// sources to target, shortest first. The number of paths grows
+// exponentially with the size of the graph, so a limit is required.
+func (g *Graph) AllPaths(sources []Node, target Node, limit int) [][]Edge {
+	dist := g.distances(target)
+
+	sorted := append([]Node{}, sources...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+
+	var paths [][]Edge
+	onPath := make(map[Node]bool)
+	var path []Edge
+	// visit records the paths from n reaching target in exactly remaining
+	// more edges, skipping nodes too far away to make it
+	var visit func(n Node, remaining int)
+	visit = func(n Node, remaining int) {
+		if len(paths) >= limit {
+			return
+		}
+		if n == target {
+			if remaining == 0 {
+				paths = append(paths, append([]Edge{}, path...))
+			}
+			return
+		}
+		onPath[n] = true
+		for _, e := range g.OutEdges(n) {
+			d, ok := dist[e.To]
+			if onPath[e.To] || !ok || d > remaining-1 {
+				continue
+			}
+			path = append(path, e)
+			visit(e.To, remaining-1)
+			path = path[:len(path)-1]
+		}
+		onPath[n] = false
+	}
+
+	// A path without cycles visits every node at most once
+	for length := 0; length < len(g.nodes); length++ {
+		for _, n := range sorted {
+			if d, ok := dist[n]; ok && d <= length {
+				visit(n, length)
+			}
+		}
+		if len(paths) >= limit {
+			break
+		}
+	}
+	return paths
+}
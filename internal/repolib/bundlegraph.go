@@ -0,0 +1,120 @@
+package repolib
+
+import (
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"github.com/intel/clear-linux-dissector/internal/graph"
+	"sort"
+)
+
+func bundleNode(name string) graph.Node {
+	return graph.Node{Kind: graph.Bundle, Name: name}
+}
+
+func packageNode(name string) graph.Node {
+	return graph.Node{Kind: graph.Package, Name: name}
+}
+
+// BundleGraph builds the dependency graph of the bundles and everything
+// they include. Bundles point to the bundles they include and the packages
+// they list directly, packages to the packages in the bundle closure
+// providing their requirements, labeled with those requirements. Packages
+// of a bundle closure not reached that way are pulled in by the bundle.
+func BundleGraph(version int, bundles []string, layout *common.Layout, trust *Trust) (*graph.Graph, error) {
+	g := graph.New()
+
+	closure := make(map[string]bool)
+	all := make(map[string][]string)
+	visited := make(map[string]bool)
+	pending := append([]string{}, bundles...)
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+
+		b, err := GetBundle(version, name, layout, trust)
+		if err != nil {
+			return nil, err
+		}
+		g.AddNode(bundleNode(name))
+
+		includes, _ := b["DirectIncludes"].([]interface{})
+		for _, i := range includes {
+			if include, ok := i.(string); ok {
+				g.AddEdge(bundleNode(name), bundleNode(include), "includes")
+				pending = append(pending, include)
+			}
+		}
+		direct, _ := b["DirectPackages"].(map[string]interface{})
+		for p := range direct {
+			g.AddEdge(bundleNode(name), packageNode(p), "contains")
+		}
+		packages, _ := b["AllPackages"].(map[string]interface{})
+		for p := range packages {
+			all[name] = append(all[name], p)
+			closure[p] = true
+		}
+	}
+
+	idx, err := loadDepIndex(version)
+	if err != nil {
+		return nil, err
+	}
+	for p := range closure {
+		for _, key := range idx.keys[p] {
+			for _, req := range idx.requires[key] {
+				for _, provider := range idx.providers[req] {
+					name := idx.names[provider]
+					if name != p && closure[name] {
+						g.AddEdge(packageNode(p), packageNode(name), req)
+					}
+				}
+			}
+		}
+	}
+
+	var names []string
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		reached := g.Reachable([]graph.Node{bundleNode(name)})
+		for _, p := range all[name] {
+			if !reached[packageNode(p)] {
+				g.AddEdge(bundleNode(name), packageNode(p), "pulls in")
+			}
+		}
+	}
+
+	return g, nil
+}
+
+// AddSourceNodes connects every package of the graph to the source rpm it
+// is built from. It needs the source repo metadata of the version.
+func AddSourceNodes(version int, g *graph.Graph) error {
+	srpmMap, err := GetPkgMap(version)
+	if err != nil {
+		return err
+	}
+	nevras, err := GetSrpmNevraMap(version)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range g.Nodes() {
+		srpm := srpmMap[n.Name]
+		if n.Kind != graph.Package || srpm == "" {
+			continue
+		}
+		nevra, err := SrpmNevra(nevras, srpm)
+		if err != nil {
+			return err
+		}
+		g.AddEdge(n, graph.Node{Kind: graph.Srpm, Name: nevra.Name},
+			"built from")
+	}
+	return nil
+}
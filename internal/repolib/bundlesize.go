@@ -0,0 +1,124 @@
+package repolib
+
+import (
+	"errors"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"sort"
+	"strconv"
+)
+
+type BundleSize struct {
+	Name          string   `json:"name"`
+	Packages      []string `json:"packages"`
+	PackageSize   int64    `json:"package_size"`
+	InstalledSize int64    `json:"installed_size"`
+	// Size of the files of the bundle manifest, 0 when not looked up
+	ContentSize int64 `json:"content_size"`
+	// Packages of the bundle that other bundles have as well
+	Shared []string `json:"shared"`
+}
+
+type SharedPackage struct {
+	Name string `json:"name"`
+	RpmSize
+	Bundles []string `json:"bundles"`
+}
+
+// SizeReport adds up the bundles, counting every package once
+type SizeReport struct {
+	Bundles       []BundleSize    `json:"bundles"`
+	Shared        []SharedPackage `json:"shared"`
+	Packages      int             `json:"packages"`
+	PackageSize   int64           `json:"package_size"`
+	InstalledSize int64           `json:"installed_size"`
+	ContentSize   int64           `json:"content_size"`
+}
+
+// GetBundleSizes sums the package sizes from the primary database over the
+// package closure of each bundle, and of the bundles they include. With
+// manifests set the size of the bundle files is read from their swupd
+// manifests as well.
+func GetBundleSizes(version int, bundles []string, layout *common.Layout, trust *Trust, manifests bool) (*SizeReport, error) {
+	sizes, err := GetPackageSizes(version)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string][]string)
+	var report SizeReport
+	visited := make(map[string]bool)
+	pending := append([]string{}, bundles...)
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+
+		b, err := GetBundle(version, name, layout, trust)
+		if err != nil {
+			return nil, err
+		}
+		includes, _ := b["DirectIncludes"].([]interface{})
+		for _, i := range includes {
+			if include, ok := i.(string); ok {
+				pending = append(pending, include)
+			}
+		}
+
+		bs := BundleSize{Name: name, Packages: []string{}, Shared: []string{}}
+		packages, _ := b["AllPackages"].(map[string]interface{})
+		for p := range packages {
+			bs.Packages = append(bs.Packages, p)
+			bs.PackageSize += sizes[p].Package
+			bs.InstalledSize += sizes[p].Installed
+			owners[p] = append(owners[p], name)
+		}
+		sort.Strings(bs.Packages)
+
+		if manifests {
+			m, err := GetBundleManifest(version, name, layout, trust)
+			if err != nil {
+				return nil, err
+			}
+			bs.ContentSize, err = strconv.ParseInt(m.Header["contentsize"],
+				10, 64)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Manifest.%s has no "+
+					"valid contentsize", name))
+			}
+			report.ContentSize += bs.ContentSize
+		}
+		report.Bundles = append(report.Bundles, bs)
+	}
+
+	report.Shared = []SharedPackage{}
+	for p, names := range owners {
+		report.Packages++
+		report.PackageSize += sizes[p].Package
+		report.InstalledSize += sizes[p].Installed
+		if len(names) > 1 {
+			sort.Strings(names)
+			report.Shared = append(report.Shared,
+				SharedPackage{Name: p, RpmSize: sizes[p], Bundles: names})
+		}
+	}
+	sort.Slice(report.Shared, func(i, j int) bool {
+		return report.Shared[i].Name < report.Shared[j].Name
+	})
+
+	for i := range report.Bundles {
+		for _, p := range report.Bundles[i].Packages {
+			if len(owners[p]) > 1 {
+				report.Bundles[i].Shared = append(report.Bundles[i].Shared, p)
+			}
+		}
+	}
+	sort.Slice(report.Bundles, func(i, j int) bool {
+		return report.Bundles[i].Name < report.Bundles[j].Name
+	})
+
+	return &report, nil
+}
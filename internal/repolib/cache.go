@@ -0,0 +1,299 @@
+package repolib
+
+import (
+	"errors"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CachedVersion describes one <version>/ tree in the working directory
+type CachedVersion struct {
+	Version    int
+	Size       int64
+	LastUsed   time.Time
+	Repo       bool
+	SrpmRepo   bool
+	Bundles    bool
+	Srpms      int
+	SrpmsDone  bool
+	Sources    int
+	Incomplete int
+}
+
+var ErrChecksumMismatch = errors.New("Checksum mismatch")
+var ErrNotInRepo = errors.New("Not listed in the source repo")
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// CachedVersions returns the versions found in the working directory,
+// oldest first
+func CachedVersions() ([]int, error) {
+	files, err := ioutil.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []int
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		v, err := strconv.Atoi(f.Name())
+		if err != nil || v < 0 {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+
+	return versions, nil
+}
+
+func StatCachedVersion(version int) (CachedVersion, error) {
+	c := CachedVersion{Version: version}
+	base := strconv.Itoa(version)
+
+	err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			c.Size += info.Size()
+		}
+		if info.ModTime().After(c.LastUsed) {
+			c.LastUsed = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return c, err
+	}
+
+	c.Repo = exists(base + "/repodata/primary.sqlite")
+	c.SrpmRepo = exists(base + "/srpms/repodata/primary.sqlite")
+	c.Bundles = exists(base + "/bundles/.complete")
+	c.SrpmsDone = exists(base + "/srpms/.done")
+
+	files, err := ioutil.ReadDir(base + "/srpms")
+	if err != nil && !os.IsNotExist(err) {
+		return c, err
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".src.rpm") {
+			c.Srpms++
+		}
+	}
+
+	files, err = ioutil.ReadDir(base + "/source")
+	if err != nil && !os.IsNotExist(err) {
+		return c, err
+	}
+	for _, f := range files {
+		if !f.IsDir() || strings.HasSuffix(f.Name(), ".tmp") {
+			continue
+		}
+		if IsExtracted(base+"/source/"+f.Name(), "") {
+			c.Sources++
+		} else {
+			c.Incomplete++
+		}
+	}
+
+	return c, nil
+}
+
+// VerifyCache checks every cached source rpm and extracted tree of the
+// version against the checksums from the source repo metadata
+func VerifyCache(version int) (map[string]error, error) {
+	hashmap, err := GetSrpmHashMap(version)
+	if err != nil {
+		return nil, err
+	}
+	if len(hashmap) == 0 {
+		return nil, errors.New(fmt.Sprintf(
+			"No source repo metadata cached for %d", version))
+	}
+
+	nevras, err := GetSrpmNevraMap(version)
+	if err != nil {
+		return nil, err
+	}
+
+	// Trees may have been extracted with either layout
+	trees := make(map[string]string)
+	for srpm, hash := range hashmap {
+		nevra, err := SrpmNevra(nevras, srpm)
+		if err != nil {
+			continue
+		}
+		for _, layout := range []string{LayoutName, LayoutNVR} {
+			dir, _ := SourceDir(nevra, layout)
+			trees[dir] = hash
+		}
+	}
+
+	failures := make(map[string]error)
+	for _, dir := range []string{"srpms", "source"} {
+		base := fmt.Sprintf("%d/%s", version, dir)
+		files, err := ioutil.ReadDir(base)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			path := base + "/" + f.Name()
+			if strings.HasSuffix(f.Name(), ".src.rpm") && !f.IsDir() {
+				hash, ok := hashmap[f.Name()]
+				if !ok {
+					failures[path] = ErrNotInRepo
+					continue
+				}
+				cs, err := downloader.ChecksumFile(path)
+				if err != nil {
+					failures[path] = err
+				} else if cs != hash {
+					failures[path] = ErrChecksumMismatch
+				}
+				continue
+			}
+
+			if dir != "source" || !f.IsDir() || !IsExtracted(path, "") {
+				continue
+			}
+			hash, ok := trees[f.Name()]
+			if !ok {
+				failures[path] = ErrNotInRepo
+			} else if !IsExtracted(path, hash) {
+				failures[path] = ErrChecksumMismatch
+			}
+		}
+	}
+
+	return failures, nil
+}
+
+// PruneVersions picks the cached versions to remove so that only the
+// newest keep versions remain and none older than max_age is left. A
+// negative keep or zero max_age disables that limit.
+func PruneVersions(keep int, max_age time.Duration) ([]int, error) {
+	versions, err := CachedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	var prune []int
+	for i, v := range versions {
+		if keep >= 0 && i < len(versions)-keep {
+			prune = append(prune, v)
+			continue
+		}
+		if max_age > 0 {
+			c, err := StatCachedVersion(v)
+			if err != nil {
+				return nil, err
+			}
+			if time.Since(c.LastUsed) > max_age {
+				prune = append(prune, v)
+			}
+		}
+	}
+
+	return prune, nil
+}
+
+// CacheLeftovers finds the temporary files of interrupted downloads and
+// the temporary trees of interrupted extractions in the cached versions.
+// Trees extracted before completion markers were written have no marker
+// and are left alone.
+func CacheLeftovers() ([]string, error) {
+	versions, err := CachedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, v := range versions {
+		err = filepath.Walk(strconv.Itoa(v), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !strings.HasSuffix(path, ".tmp") {
+				return nil
+			}
+			paths = append(paths, path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return paths, nil
+}
+
+// RemoveCached removes a path of the cache while holding the locks of the
+// downloads and extractions writing to it, failing with downloader.ErrLocked
+// when another process still holds one of them
+func RemoveCached(path string) error {
+	var locks []*os.File
+	defer func() {
+		for _, lock := range locks {
+			downloader.Unlock(lock)
+		}
+	}()
+
+	if strings.HasSuffix(path, ".tmp") {
+		// The primary database is written under the lock of its repodata
+		// directory, everything else under the lock of its final name
+		targets := []string{strings.TrimSuffix(path, ".tmp")}
+		if filepath.Base(filepath.Dir(path)) == "repodata" {
+			targets = append(targets, filepath.Dir(path)+"/")
+		}
+		for _, target := range targets {
+			lock, err := downloader.TryLock(target)
+			if err != nil {
+				return err
+			}
+			locks = append(locks, lock)
+		}
+	}
+
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, ".lock") {
+			return nil
+		}
+		lock, err := downloader.TryLock(strings.TrimSuffix(p, ".lock"))
+		if err != nil {
+			return err
+		}
+		locks = append(locks, lock)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,277 @@
+package repolib
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ChangelogEntry struct {
+	Author string    `json:"author"`
+	Date   time.Time `json:"date"`
+	Text   string    `json:"text"`
+}
+
+// SourceChanges lists what changed in one source package between two
+// versions
+type SourceChanges struct {
+	Name      string           `json:"name"`
+	Packages  []string         `json:"packages,omitempty"`
+	From      string           `json:"from,omitempty"`
+	To        string           `json:"to"`
+	CVEs      []string         `json:"cves"`
+	Patches   []string         `json:"patches,omitempty"`
+	Changelog []ChangelogEntry `json:"changelog"`
+}
+
+var cveRe = regexp.MustCompile(`(?i)CVE-\d{4}-\d{4,}`)
+
+// FindCVEs returns the CVE identifiers mentioned in the texts, sorted and
+// without duplicates
+func FindCVEs(texts ...string) []string {
+	found := make(map[string]bool)
+	for _, text := range texts {
+		for _, cve := range cveRe.FindAllString(text, -1) {
+			found[strings.ToUpper(cve)] = true
+		}
+	}
+
+	cves := []string{}
+	for cve := range found {
+		cves = append(cves, cve)
+	}
+	sort.Strings(cves)
+	return cves
+}
+
+// pkgKeys maps the pkgId of every source rpm to its key in one of the
+// other databases of the source repo
+func pkgKeys(db *sql.DB, hashmap map[string]string) (map[string]int, error) {
+	ids := make(map[string]string)
+	for srpm, id := range hashmap {
+		ids[id] = srpm
+	}
+
+	rows, err := db.Query("select pkgKey, pkgId from packages;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]int)
+	for rows.Next() {
+		var key int
+		var id string
+		err := rows.Scan(&key, &id)
+		if err != nil {
+			return nil, err
+		}
+		if srpm, ok := ids[id]; ok {
+			keys[srpm] = key
+		}
+	}
+	return keys, rows.Err()
+}
+
+// GetSrpmChangelogs reads the changelogs of all source rpms of the
+// version, newest entry first, from other.sqlite
+func GetSrpmChangelogs(version int) (map[string][]ChangelogEntry, error) {
+	hashmap, err := GetSrpmHashMap(version)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%d/srpms/repodata/other.sqlite",
+		version))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	keys, err := pkgKeys(db, hashmap)
+	if err != nil {
+		return nil, err
+	}
+	srpms := make(map[int]string)
+	for srpm, key := range keys {
+		srpms[key] = srpm
+	}
+
+	rows, err := db.Query("select pkgKey, author, date, changelog " +
+		"from changelog order by date desc;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changelogs := make(map[string][]ChangelogEntry)
+	for rows.Next() {
+		var key int
+		var date int64
+		var author, text sql.NullString
+		err := rows.Scan(&key, &author, &date, &text)
+		if err != nil {
+			return nil, err
+		}
+		srpm, ok := srpms[key]
+		if !ok {
+			continue
+		}
+		changelogs[srpm] = append(changelogs[srpm], ChangelogEntry{
+			Author: author.String,
+			Date:   time.Unix(date, 0).UTC(),
+			Text:   text.String,
+		})
+	}
+
+	return changelogs, rows.Err()
+}
+
+// GetSrpmFiles reads the names of the files inside every source rpm of
+// the version from filelists.sqlite
+func GetSrpmFiles(version int) (map[string][]string, error) {
+	hashmap, err := GetSrpmHashMap(version)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%d/srpms/repodata/filelists.sqlite",
+		version))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	keys, err := pkgKeys(db, hashmap)
+	if err != nil {
+		return nil, err
+	}
+	srpms := make(map[int]string)
+	for srpm, key := range keys {
+		srpms[key] = srpm
+	}
+
+	rows, err := db.Query("select pkgKey, dirname, filenames from filelist;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make(map[string][]string)
+	for rows.Next() {
+		var key int
+		var dirname, filenames sql.NullString
+		err := rows.Scan(&key, &dirname, &filenames)
+		if err != nil {
+			return nil, err
+		}
+		srpm, ok := srpms[key]
+		if !ok {
+			continue
+		}
+		for _, name := range strings.Split(filenames.String, "/") {
+			if name == "" {
+				continue
+			}
+			if dirname.String != "" {
+				name = dirname.String + "/" + name
+			}
+			files[srpm] = append(files[srpm], name)
+		}
+	}
+
+	for srpm := range files {
+		sort.Strings(files[srpm])
+	}
+	return files, rows.Err()
+}
+
+func isPatch(name string) bool {
+	return strings.HasSuffix(name, ".patch") || strings.HasSuffix(name, ".diff")
+}
+
+// CompareSources reports the changelog entries, new patches and the CVEs
+// they mention for each named source package, between the from and to
+// versions. Both versions need their source repo other and filelists
+// databases downloaded.
+func CompareSources(from int, to int, names []string) ([]SourceChanges, error) {
+	versions := []int{from, to}
+	var nevras [2]map[string]string
+	var changelogs [2]map[string][]ChangelogEntry
+	var files [2]map[string][]string
+	for i, v := range versions {
+		nmap, err := GetSrpmNevraMap(v)
+		if err != nil {
+			return nil, err
+		}
+		nevras[i] = make(map[string]string)
+		for srpm, nevra := range nmap {
+			nevras[i][nevra.Name] = srpm
+		}
+
+		changelogs[i], err = GetSrpmChangelogs(v)
+		if err != nil {
+			return nil, err
+		}
+		files[i], err = GetSrpmFiles(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var changes []SourceChanges
+	for _, name := range names {
+		new_srpm, ok := nevras[1][name]
+		if !ok {
+			var sources []string
+			for source := range nevras[1] {
+				sources = append(sources, source)
+			}
+			return nil, withSuggestion(fmt.Sprintf(
+				"No source package %s in version %d", name, to), name, sources)
+		}
+		old_srpm := nevras[0][name]
+
+		c := SourceChanges{
+			Name:      name,
+			To:        strings.TrimSuffix(new_srpm, ".src.rpm"),
+			From:      strings.TrimSuffix(old_srpm, ".src.rpm"),
+			Changelog: []ChangelogEntry{},
+		}
+
+		seen := make(map[ChangelogEntry]bool)
+		for _, e := range changelogs[0][old_srpm] {
+			seen[e] = true
+		}
+		var texts []string
+		for _, e := range changelogs[1][new_srpm] {
+			if !seen[e] {
+				c.Changelog = append(c.Changelog, e)
+				texts = append(texts, e.Text)
+			}
+		}
+
+		old_files := make(map[string]bool)
+		for _, f := range files[0][old_srpm] {
+			old_files[f] = true
+		}
+		for _, f := range files[1][new_srpm] {
+			if isPatch(f) && !old_files[f] {
+				c.Patches = append(c.Patches, f)
+				texts = append(texts, path.Base(f))
+			}
+		}
+
+		c.CVEs = FindCVEs(texts...)
+		changes = append(changes, c)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes, nil
+}
@@ -0,0 +1,144 @@
+package repolib
+
+import (
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rustylynch/go-rpmutils"
+)
+
+// DebugRpm is the debuginfo rpm holding the symbols and sources of a
+// binary package
+type DebugRpm struct {
+	Package rpmutils.NEVRA
+	File    string
+}
+
+// debugPrimary is the package database of the debug repo of the layer,
+// the binary one unless the layout publishes debuginfo separately
+func (l *Layer) debugPrimary() string {
+	if l.Layout.DebugRepoURL(l.Version) == l.Layout.BinaryRepoURL(l.Version) {
+		return l.primary()
+	}
+	return l.Dir + "/debuginfo/repodata/primary.sqlite"
+}
+
+// DownloadDebugLayers fetches the package databases of the layers whose
+// debuginfo packages are kept in a repo of their own
+func DownloadDebugLayers(layers []Layer, trust *Trust) error {
+	for _, l := range layers {
+		if l.debugPrimary() == l.primary() {
+			continue
+		}
+		err := os.MkdirAll(l.Dir+"/debuginfo", 0700)
+		if err != nil {
+			return err
+		}
+		err = DownloadRepoInfo(l.Dir+"/debuginfo",
+			l.Layout.DebugRepoURL(l.Version), trust)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetLayerDebugRpms finds the debuginfo rpm built along with each of the
+// packages, given with their source rpms: <source>-dbg as Clear Linux
+// names them or <package>-debuginfo, of the same version and release as
+// the package. Packages without one are left out.
+func GetLayerDebugRpms(layers []Layer, packages map[string]string) (*LayerRpms, map[string]DebugRpm, error) {
+	binaries, files, err := GetLayerBinaries(layers)
+	if err != nil {
+		return nil, nil, err
+	}
+	debug, debug_files, err := getLayerRpms(layers, (*Layer).debugPrimary,
+		func(l *Layer) string {
+			return l.Layout.DebugRepoURL(l.Version)
+		})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	found := make(map[string]DebugRpm)
+	for pkg, srpm := range packages {
+		fname, ok := files[pkg]
+		if !ok {
+			continue
+		}
+		nevra := binaries.Nevras[fname]
+
+		var candidates []string
+		if source, err := ParseRpmFilename(srpm); err == nil {
+			candidates = append(candidates, source.Name+"-dbg")
+		}
+		candidates = append(candidates, pkg+"-dbg", pkg+"-debuginfo")
+		for _, name := range candidates {
+			dfile, ok := debug_files[name]
+			if !ok {
+				continue
+			}
+			dnevra := debug.Nevras[dfile]
+			if rpmutils.NEVRAcmp(nevra, dnevra) == 0 &&
+				nevra.Arch == dnevra.Arch {
+				found[pkg] = DebugRpm{Package: nevra, File: dfile}
+				break
+			}
+		}
+	}
+	return debug, found, nil
+}
+
+// FormatNevra formats the NEVRA the way rpm -q prints packages, the epoch
+// only shown when set
+func FormatNevra(nevra rpmutils.NEVRA) string {
+	if nevra.Epoch != "" && nevra.Epoch != "0" {
+		return fmt.Sprintf("%s-%s:%s-%s.%s", nevra.Name, nevra.Epoch,
+			nevra.Version, nevra.Release, nevra.Arch)
+	}
+	return fmt.Sprintf("%s-%s-%s.%s", nevra.Name, nevra.Version,
+		nevra.Release, nevra.Arch)
+}
+
+// UpdateDebugIndex adds the entries, package NEVRAs mapped to the debug
+// trees holding their symbols and sources, to the index at path. Entries
+// of earlier runs are kept unless replaced.
+func UpdateDebugIndex(path string, entries map[string]string) error {
+	lock, err := downloader.Lock(path)
+	if err != nil {
+		return err
+	}
+	defer downloader.Unlock(lock)
+
+	index := make(map[string]string)
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) == 2 {
+			index[fields[0]] = fields[1]
+		}
+	}
+	for nevra, dir := range entries {
+		index[nevra] = dir
+	}
+
+	var lines []string
+	for nevra, dir := range index {
+		lines = append(lines, nevra+"\t"+dir+"\n")
+	}
+	sort.Strings(lines)
+
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, []byte(strings.Join(lines, "")), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,290 @@
+package repolib
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// queryFiles maps the binary packages owning any of the paths to the paths
+// they own, from the files listed in the primary database and, when it
+// has been downloaded, the filelists database
+func queryFiles(version int, paths []string) (map[string][]string, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%d/repodata/primary.sqlite",
+		version))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	r := make(map[string][]string)
+	for batch := paths; len(batch) > 0; {
+		n := len(batch)
+		if n > queryBatchSize {
+			n = queryBatchSize
+		}
+		args := make([]interface{}, n)
+		for i, p := range batch[:n] {
+			args[i] = p
+		}
+		batch = batch[n:]
+
+		query := fmt.Sprintf("SELECT DISTINCT packages.name, files.name "+
+			"FROM packages INNER JOIN files "+
+			"ON packages.pkgKey=files.pkgKey "+
+			"WHERE files.name IN (?%s);", strings.Repeat(", ?", n-1))
+		err = queryProviders(db, query, args, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	filelists := fmt.Sprintf("%d/repodata/filelists.sqlite", version)
+	if _, err := os.Stat(filelists); os.IsNotExist(err) {
+		return r, nil
+	}
+
+	// Package keys differ between the databases, the pkgId is shared
+	names := make(map[string]string)
+	rows, err := db.Query("select pkgId, name from packages;")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id, name string
+		err = rows.Scan(&id, &name)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names[id] = name
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	fdb, err := sql.Open("sqlite3", filelists)
+	if err != nil {
+		return nil, err
+	}
+	defer fdb.Close()
+
+	for _, p := range paths {
+		rows, err := fdb.Query("SELECT packages.pkgId, filelist.filenames "+
+			"FROM packages INNER JOIN filelist "+
+			"ON packages.pkgKey=filelist.pkgKey "+
+			"WHERE filelist.dirname = ?;", path.Dir(p))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id string
+			var filenames sql.NullString
+			err = rows.Scan(&id, &filenames)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			for _, f := range strings.Split(filenames.String, "/") {
+				if f == path.Base(p) && names[id] != "" {
+					r[names[id]] = append(r[names[id]], p)
+				}
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// WhatProvides maps each capability, soname or file path to the binary
+// packages providing it. Versions in the queries are not compared, only
+// the names.
+func WhatProvides(version int, queries []string) (map[string][]string, error) {
+	requirements := make(map[string]bool)
+	var paths []string
+	for _, q := range queries {
+		requirements[q] = true
+		if strings.HasPrefix(q, "/") {
+			paths = append(paths, q)
+		}
+	}
+
+	providers, err := QueryReqs(version, requirements, "name")
+	if err != nil {
+		return nil, err
+	}
+	owners, err := queryFiles(version, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]map[string]bool)
+	for _, m := range []map[string][]string{providers, owners} {
+		for pkg, reqs := range m {
+			for _, req := range reqs {
+				if found[req] == nil {
+					found[req] = make(map[string]bool)
+				}
+				found[req][pkg] = true
+			}
+		}
+	}
+
+	r := make(map[string][]string)
+	for req, pkgs := range found {
+		for pkg := range pkgs {
+			r[req] = append(r[req], pkg)
+		}
+		sort.Strings(r[req])
+	}
+	return r, nil
+}
+
+// depIndex holds what every package of a primary database provides and
+// requires, indexed both ways
+type depIndex struct {
+	names     map[int]string
+	keys      map[string][]int
+	provides  map[int][]string
+	providers map[string][]int
+	requires  map[int][]string
+	requirers map[string][]int
+}
+
+func (idx *depIndex) addProvide(key int, name string) {
+	idx.provides[key] = append(idx.provides[key], name)
+	idx.providers[name] = append(idx.providers[name], key)
+}
+
+func loadDepIndex(version int) (*depIndex, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%d/repodata/primary.sqlite",
+		version))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	idx := depIndex{
+		names:     make(map[int]string),
+		keys:      make(map[string][]int),
+		provides:  make(map[int][]string),
+		providers: make(map[string][]int),
+		requires:  make(map[int][]string),
+		requirers: make(map[string][]int),
+	}
+
+	for _, q := range []struct {
+		query string
+		add   func(int, string)
+	}{
+		{"select pkgKey, name from packages;", func(key int, name string) {
+			idx.names[key] = name
+			idx.keys[name] = append(idx.keys[name], key)
+		}},
+		{"select pkgKey, name from provides;", idx.addProvide},
+		{"select pkgKey, name from files;", idx.addProvide},
+		{"select pkgKey, name from requires;", func(key int, name string) {
+			idx.requires[key] = append(idx.requires[key], name)
+			idx.requirers[name] = append(idx.requirers[name], key)
+		}},
+	} {
+		rows, err := db.Query(q.query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var key int
+			var name string
+			err = rows.Scan(&key, &name)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			q.add(key, name)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &idx, nil
+}
+
+// WhatRequires maps the binary packages requiring anything the named
+// packages provide, or any file they contain, to those requirements. With
+// recursive set the packages requiring those are included too, until
+// nothing more depends on the set.
+func WhatRequires(version int, packages []string, recursive bool) (map[string][]string, error) {
+	idx, err := loadDepIndex(version)
+	if err != nil {
+		return nil, err
+	}
+
+	var all_names []string
+	for name := range idx.keys {
+		all_names = append(all_names, name)
+	}
+
+	visited := make(map[int]bool)
+	var queue []int
+	for _, name := range packages {
+		keys, ok := idx.keys[name]
+		if !ok {
+			return nil, withSuggestion(fmt.Sprintf(
+				"No package %s in version %d", name, version), name, all_names)
+		}
+		for _, key := range keys {
+			visited[key] = true
+			queue = append(queue, key)
+		}
+	}
+
+	found := make(map[string]map[string]bool)
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+
+		for _, cap := range idx.provides[key] {
+			for _, requirer := range idx.requirers[cap] {
+				if requirer == key {
+					continue
+				}
+				name := idx.names[requirer]
+				if found[name] == nil {
+					found[name] = make(map[string]bool)
+				}
+				found[name][cap] = true
+				if recursive && !visited[requirer] {
+					visited[requirer] = true
+					queue = append(queue, requirer)
+				}
+			}
+		}
+	}
+
+	// The queried packages depending on each other are not reported
+	for _, name := range packages {
+		delete(found, name)
+	}
+
+	r := make(map[string][]string)
+	for name, caps := range found {
+		for cap := range caps {
+			r[name] = append(r[name], cap)
+		}
+		sort.Strings(r[name])
+	}
+	return r, nil
+}
@@ -0,0 +1,53 @@
+package repolib
+
+// PackageChange is a binary package built from another source rpm in
+// the newer version
+type PackageChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// PackageDiff lists the binary packages added, removed and rebuilt
+// between two versions, with their source rpms
+type PackageDiff struct {
+	From    int                      `json:"from"`
+	To      int                      `json:"to"`
+	Added   map[string]string        `json:"added"`
+	Removed map[string]string        `json:"removed"`
+	Changed map[string]PackageChange `json:"changed"`
+}
+
+// ComparePackages compares the binary packages of two versions, both
+// needing their primary database downloaded
+func ComparePackages(from int, to int) (*PackageDiff, error) {
+	old_pkgs, err := GetPkgMap(from)
+	if err != nil {
+		return nil, err
+	}
+	new_pkgs, err := GetPkgMap(to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := PackageDiff{
+		From:    from,
+		To:      to,
+		Added:   make(map[string]string),
+		Removed: make(map[string]string),
+		Changed: make(map[string]PackageChange),
+	}
+	for pkg, srpm := range new_pkgs {
+		old_srpm, ok := old_pkgs[pkg]
+		if !ok {
+			diff.Added[pkg] = srpm
+		} else if old_srpm != srpm {
+			diff.Changed[pkg] = PackageChange{From: old_srpm, To: srpm}
+		}
+	}
+	for pkg, srpm := range old_pkgs {
+		if _, ok := new_pkgs[pkg]; !ok {
+			diff.Removed[pkg] = srpm
+		}
+	}
+	return &diff, nil
+}
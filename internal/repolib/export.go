@@ -0,0 +1,310 @@
+package repolib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// SourceArchive describes the corresponding source shipped for a set of
+// bundles or an image
+type SourceArchive struct {
+	Name     string // distribution, Clear Linux OS or the mix when empty
+	Version  int
+	Image    string
+	Bundles  []string
+	URL      string
+	Packages map[string]string // binary package -> srpm
+	Srpms    map[string]string // srpm -> sha256
+	Licenses map[string]string // srpm -> license
+
+	// Repos records the repo of a mix each srpm came from, and RepoURLs
+	// where the srpms of each repo are published instead of URL
+	Repos    map[string]string
+	RepoURLs map[string]string
+}
+
+func GetSrpmLicenses(version int) (map[string]string, error) {
+	return getSrpmLicenses(fmt.Sprintf("%d/srpms/repodata/primary.sqlite",
+		version))
+}
+
+func getSrpmLicenses(db_path string) (map[string]string, error) {
+	lmap := make(map[string]string)
+	db, err := sql.Open("sqlite3", db_path)
+	if err != nil {
+		return lmap, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select location_href, rpm_license from packages;")
+	if err != nil {
+		return lmap, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var srpm string
+		var license sql.NullString
+		err := rows.Scan(&srpm, &license)
+		if err != nil {
+			return lmap, err
+		}
+		lmap[srpm] = license.String
+	}
+
+	return lmap, rows.Err()
+}
+
+// archiveTime honours SOURCE_DATE_EPOCH so the archive can be matched to
+// a build, everything else about the archive is fixed
+func archiveTime() time.Time {
+	epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64)
+	if err != nil {
+		epoch = 0
+	}
+	return time.Unix(epoch, 0).UTC()
+}
+
+func (a *SourceArchive) srpmNames() []string {
+	var srpms []string
+	for srpm := range a.Srpms {
+		srpms = append(srpms, srpm)
+	}
+	sort.Strings(srpms)
+	return srpms
+}
+
+func (a *SourceArchive) index() []byte {
+	var packages []string
+	for p := range a.Packages {
+		packages = append(packages, p)
+	}
+	sort.Strings(packages)
+
+	var b bytes.Buffer
+	if len(a.Repos) > 0 {
+		fmt.Fprintf(&b, "# package\tsrpm\tsha256\trepo\n")
+	} else {
+		fmt.Fprintf(&b, "# package\tsrpm\tsha256\n")
+	}
+	for _, p := range packages {
+		srpm := a.Packages[p]
+		if len(a.Repos) > 0 {
+			fmt.Fprintf(&b, "%s\t%s\t%s\t%s\n", p, srpm, a.Srpms[srpm],
+				a.Repos[srpm])
+		} else {
+			fmt.Fprintf(&b, "%s\t%s\t%s\n", p, srpm, a.Srpms[srpm])
+		}
+	}
+	return b.Bytes()
+}
+
+// title names the distribution the sources belong to
+func (a *SourceArchive) title() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	if len(a.RepoURLs) > 0 {
+		var repos []string
+		for repo := range a.RepoURLs {
+			repos = append(repos, repo)
+		}
+		sort.Strings(repos)
+		return fmt.Sprintf("the mix of the %s repos", strings.Join(repos, " and "))
+	}
+	return "Clear Linux OS"
+}
+
+var notSlug = regexp.MustCompile("[^a-z0-9]+")
+
+// prefix is the directory the archive unpacks into, named after the
+// distribution
+func (a *SourceArchive) prefix() string {
+	name := "clear-linux"
+	if len(a.RepoURLs) > 0 || a.Name != "" {
+		name = strings.Trim(notSlug.ReplaceAllString(strings.ToLower(a.Name),
+			"-"), "-")
+		if name == "" {
+			name = "mix"
+		}
+	}
+	return fmt.Sprintf("%s-%d-source/", name, a.Version)
+}
+
+func (a *SourceArchive) readme() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Corresponding source for %s, version %d\n\n", a.title(),
+		a.Version)
+	if a.Image != "" {
+		fmt.Fprintf(&b, "Image: %s\n", a.Image)
+	}
+	if len(a.Bundles) > 0 {
+		seen := make(map[string]bool)
+		var bundles []string
+		for _, bundle := range a.Bundles {
+			if !seen[bundle] {
+				seen[bundle] = true
+				bundles = append(bundles, bundle)
+			}
+		}
+		sort.Strings(bundles)
+		fmt.Fprintf(&b, "Bundles: %s\n", strings.Join(bundles, " "))
+	}
+	fmt.Fprintf(&b, "\nThis archive holds the source rpms for all %d binary "+
+		"packages\nlisted in INDEX, together with the sha256 sum of each "+
+		"source rpm.\nEvery source rpm contains the unmodified upstream "+
+		"sources, the\npatches applied to them and the spec file with the "+
+		"build scripts.\n\n", len(a.Packages))
+	if len(a.RepoURLs) > 0 {
+		var repos []string
+		for repo := range a.RepoURLs {
+			repos = append(repos, repo)
+		}
+		sort.Strings(repos)
+		fmt.Fprintf(&b, "The same source rpms are published at\n")
+		for _, repo := range repos {
+			fmt.Fprintf(&b, "%s: %s\n", repo, a.RepoURLs[repo])
+		}
+		fmt.Fprintf(&b, "\n")
+	} else if a.URL != "" {
+		fmt.Fprintf(&b, "The same source rpms are published at\n%s\n\n", a.URL)
+	}
+	fmt.Fprintf(&b, "The recipient of a binary image built from these "+
+		"packages may\nrequest a copy of this source, on a medium customarily "+
+		"used for\nsoftware interchange and for no more than the cost of "+
+		"physically\nperforming the distribution, from the party that "+
+		"distributed the\nimage for at least three years after the image was "+
+		"last distributed.\n\n")
+
+	fmt.Fprintf(&b, "Source rpms and their licenses:\n\n")
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	for _, srpm := range a.srpmNames() {
+		if len(a.Repos) > 0 {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", srpm, a.Repos[srpm],
+				a.Licenses[srpm])
+		} else {
+			fmt.Fprintf(w, "%s\t%s\n", srpm, a.Licenses[srpm])
+		}
+	}
+	w.Flush()
+
+	return b.Bytes()
+}
+
+// WriteSourceArchive writes the source rpms of the archive from the cache
+// of the version into a tar file, gzip compressed when path ends in .gz.
+// Entries are sorted and carry fixed owners and times so the same input
+// always gives the same archive.
+func WriteSourceArchive(path string, a *SourceArchive) error {
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+
+	var w io.Writer = out
+	var gz *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(out)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+
+	mtime := archiveTime()
+	prefix := a.prefix()
+	header := func(name string, mode int64, size int64, typeflag byte) *tar.Header {
+		return &tar.Header{Name: prefix + name, Mode: mode, Size: size,
+			Typeflag: typeflag, ModTime: mtime, Uname: "root",
+			Gname: "root", Format: tar.FormatPAX}
+	}
+
+	err = tw.WriteHeader(header("", 0755, 0, tar.TypeDir))
+	if err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		name    string
+		content []byte
+	}{{"INDEX", a.index()}, {"README", a.readme()}} {
+		err = tw.WriteHeader(header(f.name, 0644, int64(len(f.content)),
+			tar.TypeReg))
+		if err != nil {
+			return err
+		}
+		if _, err = tw.Write(f.content); err != nil {
+			return err
+		}
+	}
+
+	err = tw.WriteHeader(header("SRPMS/", 0755, 0, tar.TypeDir))
+	if err != nil {
+		return err
+	}
+	for _, srpm := range a.srpmNames() {
+		err = addSrpm(tw, fmt.Sprintf("%d/srpms/%s", a.Version, srpm),
+			header("SRPMS/"+srpm, 0644, 0, tar.TypeReg), a.Srpms[srpm])
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err = gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func addSrpm(tw *tar.Writer, path string, header *tar.Header, checksum string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header.Size = info.Size()
+	err = tw.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+
+	// The index promises these sums, so check them on the way in
+	hash := sha256.New()
+	_, err = io.Copy(tw, io.TeeReader(f, hash))
+	if err != nil {
+		return err
+	}
+	if checksum != "" && hex.EncodeToString(hash.Sum(nil)) != checksum {
+		return errors.New(fmt.Sprintf("Checksum mismatch for %s", path))
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package repolib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type ImagePartition struct {
+	Disk      string `json:"disk"`
+	Partition int    `json:"partition"`
+	Size      string `json:"size,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Mount     string `json:"mount,omitempty"`
+}
+
+type imageConfig struct {
+	DestinationType      string
+	PartitionLayout      []ImagePartition
+	FilesystemTypes      []ImagePartition
+	PartitionMountPoints []ImagePartition
+	Version              interface{}
+	Bundles              []string
+	LegacyBios           bool
+	PostNonChroot        []string
+	PostChroot           []string
+}
+
+// Image is an image definition as published, with typed accessors for the
+// keys the tools understand. It marshals back to the original document.
+type Image struct {
+	raw    json.RawMessage
+	config imageConfig
+}
+
+func (image *Image) MarshalJSON() ([]byte, error) {
+	return image.raw, nil
+}
+
+func (image *Image) DestinationType() string {
+	return image.config.DestinationType
+}
+
+func (image *Image) PartitionLayout() []ImagePartition {
+	return image.config.PartitionLayout
+}
+
+func (image *Image) FilesystemTypes() []ImagePartition {
+	return image.config.FilesystemTypes
+}
+
+func (image *Image) PartitionMountPoints() []ImagePartition {
+	return image.config.PartitionMountPoints
+}
+
+// Version is written either as a number or a string
+func (image *Image) Version() string {
+	if image.config.Version == nil {
+		return ""
+	}
+	return fmt.Sprint(image.config.Version)
+}
+
+func (image *Image) Bundles() []string {
+	return image.config.Bundles
+}
+
+// Kernel is the kernel bundle the image includes, images have no key of
+// their own for it
+func (image *Image) Kernel() string {
+	for _, b := range image.config.Bundles {
+		if strings.HasPrefix(b, "kernel-") {
+			return b
+		}
+	}
+	return ""
+}
+
+func (image *Image) LegacyBios() bool {
+	return image.config.LegacyBios
+}
+
+func (image *Image) PostNonChroot() []string {
+	return image.config.PostNonChroot
+}
+
+func (image *Image) PostChroot() []string {
+	return image.config.PostChroot
+}
+
+var imageConfigRe = regexp.MustCompile(`href="([^"/]+)-config\.json"`)
+
+func ImageConfigURL(version int, name string, layout *common.Layout) string {
+	return fmt.Sprintf("%s/%s-config.json", layout.ImagesURL(version), name)
+}
+
+func ParseImage(content []byte) (*Image, error) {
+	image := Image{raw: content}
+	err := json.Unmarshal(content, &image.config)
+	if err != nil {
+		return nil, errors.New("Corrupt image definition: " + err.Error())
+	}
+
+	if len(image.config.Bundles) == 0 {
+		return nil, errors.New("Image definition does not list any bundles")
+	}
+
+	return &image, nil
+}
+
+func ReadImage(path string) (*Image, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseImage(content)
+}
+
+func GetImage(version int, name string, layout *common.Layout) (*Image, error) {
+	if name == "" {
+		return nil, errors.New("No image name given")
+	}
+
+	resp, err := http.Get(ImageConfigURL(version, name, layout))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		msg := fmt.Sprintf("Image \"%s\" for version %d was not found on "+
+			"the server", name, version)
+		images, _ := ListImages(version, layout)
+		return nil, withSuggestion(msg, name, images)
+	}
+
+	return ParseImage(body)
+}
+
+func ListImages(version int, layout *common.Layout) ([]string, error) {
+	config_url := layout.ImagesURL(version) + "/"
+
+	resp, err := http.Get(config_url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf(
+			"No image definitions found for version %d", version))
+	}
+
+	found := make(map[string]bool)
+	for _, m := range imageConfigRe.FindAllStringSubmatch(string(body), -1) {
+		name, err := url.PathUnescape(m[1])
+		if err != nil {
+			continue
+		}
+		found[name] = true
+	}
+
+	var images []string
+	for name := range found {
+		images = append(images, name)
+	}
+	sort.Strings(images)
+
+	return images, nil
+}
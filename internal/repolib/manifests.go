@@ -0,0 +1,158 @@
+package repolib
+
+import (
+	"errors"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/swupd"
+	"io/ioutil"
+	"os"
+)
+
+// fetchCached returns the content of path, downloading it from url first
+// when it is not there yet
+func fetchCached(path string, url string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err == nil {
+		return content, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Another process may be downloading it too, check again once it is
+	// done
+	lock, err := downloader.Lock(path)
+	if err != nil {
+		return nil, err
+	}
+	defer downloader.Unlock(lock)
+	content, err = ioutil.ReadFile(path)
+	if err == nil {
+		return content, nil
+	}
+
+	content, err = fetch(url)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(path+".tmp", content, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return content, os.Rename(path+".tmp", path)
+}
+
+// dropCached removes a damaged copy of path from the cache
+func dropCached(path string) error {
+	lock, err := downloader.Lock(path)
+	if err != nil {
+		return err
+	}
+	defer downloader.Unlock(lock)
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// verifyMoM checks the signature on Manifest.MoM of the update content at
+// update_url when trust has certificates, the signature being kept in dir
+func verifyMoM(mom []byte, dir string, update_url string, trust *Trust) error {
+	if trust == nil || len(trust.Certificates) == 0 {
+		return nil
+	}
+	signature, err := fetchCached(dir+"/Manifest.MoM.sig",
+		update_url+"/Manifest.MoM.sig")
+	if err != nil {
+		return err
+	}
+	err = swupd.VerifySignature(mom, signature, trust.Certificates)
+	if err != nil {
+		return errors.New("Bad signature on Manifest.MoM: " + err.Error())
+	}
+	return nil
+}
+
+// getMoM returns Manifest.MoM of the update content at update_url, kept
+// in dir
+func getMoM(dir string, update_url string, trust *Trust) (*swupd.Manifest, error) {
+	mom, err := fetchCached(dir+"/Manifest.MoM", update_url+"/Manifest.MoM")
+	if err != nil {
+		return nil, err
+	}
+	err = verifyMoM(mom, dir, update_url, trust)
+	if err != nil {
+		// A copy cached without verification is replaced once, a bad
+		// download is an error
+		for _, name := range []string{"/Manifest.MoM", "/Manifest.MoM.sig"} {
+			if err := dropCached(dir + name); err != nil {
+				return nil, err
+			}
+		}
+		mom, err = fetchCached(dir+"/Manifest.MoM", update_url+"/Manifest.MoM")
+		if err != nil {
+			return nil, err
+		}
+		err = verifyMoM(mom, dir, update_url, trust)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return swupd.ParseManifest(mom)
+}
+
+// GetBundleManifest returns the swupd manifest listing the files of the
+// bundle, checked against Manifest.MoM. The manifests are kept in
+// <version>/manifests.
+func GetBundleManifest(version int, name string, layout *common.Layout, trust *Trust) (*swupd.Manifest, error) {
+	dir := fmt.Sprintf("%d/manifests", version)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := getMoM(dir, layout.UpdateURL(version), trust)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := m.Find(name)
+	if !ok {
+		var names []string
+		for _, e := range m.Entries {
+			names = append(names, e.Name)
+		}
+		return nil, withSuggestion(fmt.Sprintf(
+			"Manifest.MoM of version %d does not list bundle %s", version,
+			name), name, names)
+	}
+
+	// Bundles unchanged since an earlier release point at its manifest
+	path := fmt.Sprintf("%s/Manifest.%s.%d", dir, name, entry.Version)
+	manifest_url := fmt.Sprintf("%s/Manifest.%s",
+		layout.UpdateURL(entry.Version), name)
+	content, err := fetchCached(path, manifest_url)
+	if err != nil {
+		return nil, err
+	}
+	if swupd.Hash(content, 0100644, 0, 0) != entry.Hash {
+		// A damaged copy is replaced once, a bad download is an error
+		err = dropCached(path)
+		if err != nil {
+			return nil, err
+		}
+		content, err = fetchCached(path, manifest_url)
+		if err != nil {
+			return nil, err
+		}
+		if swupd.Hash(content, 0100644, 0, 0) != entry.Hash {
+			dropCached(path)
+			return nil, errors.New(fmt.Sprintf("Manifest.%s does not "+
+				"match Manifest.MoM", name))
+		}
+	}
+
+	return swupd.ParseManifest(content)
+}
@@ -0,0 +1,183 @@
+package repolib
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/swupd"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// VersionCachePaths returns where the tools may keep the file at the CDN
+// path p in the version cache of the working directory
+func VersionCachePaths(p string) []string {
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	if len(parts) < 3 {
+		return nil
+	}
+	if _, err := strconv.Atoi(parts[1]); err != nil {
+		return nil
+	}
+	version := parts[1]
+
+	switch {
+	case parts[0] == "releases" && len(parts) == 6 && parts[2] == "clear" &&
+		parts[3] == "source" && parts[4] == "SRPMS" &&
+		strings.HasSuffix(parts[5], ".src.rpm"):
+		// dissector and downloadpackages keep them apart
+		return []string{version + "/srpms/" + parts[5],
+			version + "/source/" + parts[5]}
+	case parts[0] == "releases" && len(parts) == 7 && parts[2] == "clear" &&
+		(parts[4] == "os" || parts[4] == "debug") && parts[5] == "Packages" &&
+		strings.HasSuffix(parts[6], ".rpm"):
+		return []string{version + "/rpms/" + parts[6]}
+	case parts[0] == "update" && len(parts) == 3 &&
+		(parts[2] == "Manifest.MoM" || parts[2] == "Manifest.MoM.sig"):
+		return []string{version + "/manifests/" + parts[2]}
+	case parts[0] == "update" && len(parts) == 3 &&
+		strings.HasPrefix(parts[2], "Manifest.") &&
+		!strings.HasSuffix(parts[2], ".tar") &&
+		!strings.HasSuffix(parts[2], ".sig"):
+		// Bundle manifests are kept with the version they changed in
+		return []string{version + "/manifests/" + parts[2] + "." + version}
+	}
+	return nil
+}
+
+// MirrorVerifier returns the check of the files of a mirror of upstream
+// cached in dir. Repo metadata is checked against repomd.xml and rpms
+// against the primary database of their repo, both kept in dir/.repos
+// once a file of the repo was requested. Manifest.MoM is checked against
+// its signature when trust has certificates and the bundle manifests
+// against Manifest.MoM, kept in dir/.update. The rest of the update
+// content is verified by swupd itself.
+func MirrorVerifier(dir string, upstream string, trust *Trust) func(string, string) error {
+	upstream = strings.TrimSuffix(upstream, "/")
+	return func(p string, file string) error {
+		if strings.HasPrefix(p, "/update/") {
+			return verifyUpdateFile(dir, upstream, p, file, trust)
+		}
+
+		var root, href string
+		if i := strings.LastIndex(p, "/repodata/"); i >= 0 {
+			root, href = p[:i], p[i+1:]
+			if href == "repodata/repomd.xml" || href == "repodata/repomd.xml.asc" {
+				return nil
+			}
+		} else if strings.HasSuffix(p, ".rpm") {
+			root, href = path.Split(p)
+			root = strings.TrimSuffix(root, "/")
+			if path.Base(root) == "Packages" {
+				root, href = path.Dir(root), "Packages/"+href
+			}
+		} else {
+			return nil
+		}
+
+		meta := dir + "/.repos" + root
+		err := DownloadRepoInfo(meta, upstream+root, trust)
+		if err != nil {
+			return err
+		}
+
+		var checksum string
+		if strings.HasPrefix(href, "repodata/") {
+			checksum, err = repomdChecksum(meta, href)
+		} else {
+			checksum, err = rpmChecksum(meta, href)
+		}
+		if err != nil {
+			return err
+		}
+		actual, err := downloader.ChecksumFile(file)
+		if err != nil {
+			return err
+		}
+		if actual != checksum {
+			return errors.New(fmt.Sprintf("%s does not match its repo metadata", p))
+		}
+		return nil
+	}
+}
+
+// verifyUpdateFile checks Manifest.MoM and the bundle manifests it lists
+// of the update content at upstream/update/<version>
+func verifyUpdateFile(dir string, upstream string, p string, file string, trust *Trust) error {
+	version, name := path.Split(strings.TrimPrefix(p, "/update/"))
+	version = strings.TrimSuffix(version, "/")
+	v, err := strconv.Atoi(version)
+	if err != nil || !strings.HasPrefix(name, "Manifest.") ||
+		strings.HasSuffix(name, ".sig") || strings.HasSuffix(name, ".tar") {
+		return nil
+	}
+
+	meta := dir + "/.update/" + version
+	err = os.MkdirAll(meta, 0755)
+	if err != nil {
+		return err
+	}
+	update_url := upstream + "/update/" + version
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if name == "Manifest.MoM" {
+		return verifyMoM(content, meta, update_url, trust)
+	}
+
+	m, err := getMoM(meta, update_url, trust)
+	if err != nil {
+		return err
+	}
+	bundle := strings.TrimPrefix(name, "Manifest.")
+	entry, ok := m.Find(bundle)
+	if !ok {
+		// Such as Manifest.full, left to swupd
+		return nil
+	}
+	if entry.Version != v || swupd.Hash(content, 0100644, 0, 0) != entry.Hash {
+		return errors.New(fmt.Sprintf("%s does not match Manifest.MoM", p))
+	}
+	return nil
+}
+
+func repomdChecksum(meta string, href string) (string, error) {
+	content, err := ioutil.ReadFile(meta + "/repodata/repomd.xml")
+	if err != nil {
+		return "", err
+	}
+	var repomd Repomd
+	err = xml.Unmarshal(content, &repomd)
+	if err != nil {
+		return "", err
+	}
+	for _, data := range repomd.Data {
+		if data.Location.Href == href {
+			return data.Checksum.Value, nil
+		}
+	}
+	return "", errors.New(fmt.Sprintf("%s is not listed in repomd.xml", href))
+}
+
+func rpmChecksum(meta string, href string) (string, error) {
+	db, err := sql.Open("sqlite3", meta+"/repodata/primary.sqlite")
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	var checksum string
+	err = db.QueryRow("select pkgId from packages where location_href = ?;",
+		href).Scan(&checksum)
+	if err == sql.ErrNoRows {
+		return "", errors.New(fmt.Sprintf("%s is not listed in its repo", href))
+	}
+	return checksum, err
+}
@@ -0,0 +1,337 @@
+package repolib
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rustylynch/go-rpmutils"
+)
+
+// Layer is one of the package repos the sources of a release are looked
+// up in, its metadata cached in Dir the way a version caches its own
+type Layer struct {
+	Name    string
+	Version int
+	Layout  *common.Layout
+	Dir     string
+}
+
+// ReleaseLayers returns the single layer of a plain release
+func ReleaseLayers(version int, layout *common.Layout) []Layer {
+	return []Layer{{Name: "clear", Version: version, Layout: layout,
+		Dir: fmt.Sprintf("%d", version)}}
+}
+
+type MixRepo struct {
+	Name    string
+	Version int
+	Layout  *common.Layout
+}
+
+// Mix is a distribution built with mixer. Its update content holds the
+// bundle definitions while its packages come from several repos, each
+// taking precedence over the ones after it.
+type Mix struct {
+	Name   string
+	Layout *common.Layout
+	Repos  []MixRepo
+}
+
+type mixRepoConfig struct {
+	Name    string          `json:"name"`
+	URL     string          `json:"url"`
+	Version int             `json:"version"`
+	Layout  json.RawMessage `json:"layout"`
+}
+
+type mixConfig struct {
+	Name   string          `json:"name"`
+	URL    string          `json:"url"`
+	Layout json.RawMessage `json:"layout"`
+	Repos  []mixRepoConfig `json:"repos"`
+}
+
+// LoadMix reads the JSON description of a mix. Repos without a version
+// follow the version of the mix and repos without a URL are served with
+// the mix. A non empty arch overrides the one of every layout.
+func LoadMix(path string, arch string) (*Mix, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config mixConfig
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Corrupt mix %s: %s", path, err))
+	}
+	if config.URL == "" || len(config.Repos) == 0 {
+		return nil, errors.New(fmt.Sprintf("Mix %s needs a url and repos",
+			path))
+	}
+
+	mix := Mix{Name: config.Name}
+	mix.Layout, err = common.ParseLayout(config.Layout, config.URL, arch)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", path, err))
+	}
+
+	names := make(map[string]bool)
+	for _, r := range config.Repos {
+		if r.Name == "" || r.Name[0] == '.' || strings.Contains(r.Name, "/") {
+			return nil, errors.New(fmt.Sprintf("Invalid repo name \"%s\" "+
+				"in %s", r.Name, path))
+		}
+		if names[r.Name] {
+			return nil, errors.New(fmt.Sprintf("Repo %s is listed twice "+
+				"in %s", r.Name, path))
+		}
+		names[r.Name] = true
+
+		url := r.URL
+		if url == "" {
+			url = config.URL
+		}
+		layout, err := common.ParseLayout(r.Layout, url, arch)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: repo %s: %s", path,
+				r.Name, err))
+		}
+		mix.Repos = append(mix.Repos, MixRepo{Name: r.Name,
+			Version: r.Version, Layout: layout})
+	}
+	return &mix, nil
+}
+
+// Layers returns the repos of a version of the mix, cached below the
+// version in repos/<name>
+func (m *Mix) Layers(version int) []Layer {
+	var layers []Layer
+	for _, r := range m.Repos {
+		v := r.Version
+		if v == 0 {
+			v = version
+		}
+		layers = append(layers, Layer{Name: r.Name, Version: v,
+			Layout: r.Layout, Dir: fmt.Sprintf("%d/repos/%s", version, r.Name)})
+	}
+	return layers
+}
+
+func (l *Layer) primary() string {
+	return l.Dir + "/repodata/primary.sqlite"
+}
+
+func (l *Layer) srpmPrimary() string {
+	return l.Dir + "/srpms/repodata/primary.sqlite"
+}
+
+// DownloadLayers fetches the package databases of every layer and
+// prepares the directories the sources of version are kept in
+func DownloadLayers(version int, layers []Layer, trust *Trust) error {
+	for _, l := range layers {
+		err := DownloadRepoInfo(l.Dir, l.Layout.BinaryRepoURL(l.Version),
+			trust)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := os.MkdirAll(fmt.Sprintf("%d/source", version), 0700)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(fmt.Sprintf("%d/srpms", version), 0700)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range layers {
+		err = os.MkdirAll(l.Dir+"/srpms", 0700)
+		if err != nil {
+			return err
+		}
+		err = DownloadRepoInfo(l.Dir+"/srpms",
+			l.Layout.SourceRepoURL(l.Version), trust)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueryLayers works like QueryReqs across the layers, every requirement
+// being satisfied by the first layer providing it
+func QueryLayers(layers []Layer, requirements map[string]bool, field string) (map[string][]string, error) {
+	remaining := make(map[string]bool)
+	for req := range requirements {
+		remaining[req] = true
+	}
+
+	r := make(map[string][]string)
+	for _, l := range layers {
+		if len(remaining) == 0 {
+			break
+		}
+		found, err := queryReqs(l.primary(), remaining, field)
+		if err != nil {
+			return nil, err
+		}
+		for value, reqs := range found {
+			r[value] = append(r[value], reqs...)
+			for _, req := range reqs {
+				delete(remaining, req)
+			}
+		}
+	}
+	return r, nil
+}
+
+// GetLayerPkgMap maps every binary package to its source rpm like
+// GetPkgMap, packages of earlier layers hiding those of later ones
+func GetLayerPkgMap(layers []Layer) (map[string]string, error) {
+	pmap := make(map[string]string)
+	for i := len(layers) - 1; i >= 0; i-- {
+		m, err := getPkgMap(layers[i].primary())
+		if err != nil {
+			return nil, err
+		}
+		for name, srpm := range m {
+			pmap[name] = srpm
+		}
+	}
+	return pmap, nil
+}
+
+// LayerRpms holds what the repos of the layers record about their rpms,
+// keyed by file name and each taken from the first layer that has it
+type LayerRpms struct {
+	Origins  map[string]*Layer
+	URLs     map[string]string
+	Hashes   map[string]string
+	Nevras   map[string]rpmutils.NEVRA
+	Sizes    map[string]RpmSize
+	Licenses map[string]string
+}
+
+func newLayerRpms() *LayerRpms {
+	return &LayerRpms{
+		Origins:  make(map[string]*Layer),
+		URLs:     make(map[string]string),
+		Hashes:   make(map[string]string),
+		Nevras:   make(map[string]rpmutils.NEVRA),
+		Sizes:    make(map[string]RpmSize),
+		Licenses: make(map[string]string),
+	}
+}
+
+// GetLayerSources reads the source rpms of the layers
+func GetLayerSources(layers []Layer) (*LayerRpms, error) {
+	s := newLayerRpms()
+	for i := len(layers) - 1; i >= 0; i-- {
+		l := &layers[i]
+		hashes, err := getSrpmHashMap(l.srpmPrimary())
+		if err != nil {
+			return nil, err
+		}
+		nevras, err := getSrpmNevraMap(l.srpmPrimary())
+		if err != nil {
+			return nil, err
+		}
+		sizes, err := getSizes(l.srpmPrimary(), "location_href")
+		if err != nil {
+			return nil, err
+		}
+		licenses, err := getSrpmLicenses(l.srpmPrimary())
+		if err != nil {
+			return nil, err
+		}
+
+		for srpm, hash := range hashes {
+			s.Origins[srpm] = l
+			s.URLs[srpm] = l.Layout.SrpmURL(l.Version, srpm)
+			s.Hashes[srpm] = hash
+			s.Nevras[srpm] = nevras[srpm]
+			s.Sizes[srpm] = sizes[srpm]
+			s.Licenses[srpm] = licenses[srpm]
+		}
+	}
+	return s, nil
+}
+
+// GetLayerBinaries reads the binary rpms of the layers and maps every
+// package name to the file of the first layer providing the package
+func GetLayerBinaries(layers []Layer) (*LayerRpms, map[string]string, error) {
+	return getLayerRpms(layers, (*Layer).primary, func(l *Layer) string {
+		return l.Layout.BinaryRepoURL(l.Version)
+	})
+}
+
+func getLayerRpms(layers []Layer, primary func(*Layer) string, repo_url func(*Layer) string) (*LayerRpms, map[string]string, error) {
+	s := newLayerRpms()
+	files := make(map[string]string)
+	for i := len(layers) - 1; i >= 0; i-- {
+		l := &layers[i]
+		sizes, err := getSizes(primary(l), "location_href")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		db, err := sql.Open("sqlite3", primary(l))
+		if err != nil {
+			return nil, nil, err
+		}
+		rows, err := db.Query("select name, location_href, pkgId, epoch, " +
+			"version, release, arch, rpm_license from packages;")
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		for rows.Next() {
+			var href, hash string
+			var nevra rpmutils.NEVRA
+			var epoch, license sql.NullString
+			err = rows.Scan(&nevra.Name, &href, &hash, &epoch, &nevra.Version,
+				&nevra.Release, &nevra.Arch, &license)
+			if err != nil {
+				break
+			}
+			nevra.Epoch = epoch.String
+
+			fname := path.Base(href)
+			files[nevra.Name] = fname
+			s.Origins[fname] = l
+			s.URLs[fname] = repo_url(l) + "/" + href
+			s.Hashes[fname] = hash
+			s.Nevras[fname] = nevra
+			s.Sizes[fname] = sizes[href]
+			s.Licenses[fname] = license.String
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		rows.Close()
+		db.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return s, files, nil
+}
+
+// URL returns where the rpm is downloaded from, an unknown one being
+// looked for in the source repo of the first layer
+func (s *LayerRpms) URL(layers []Layer, fname string) string {
+	if url, ok := s.URLs[fname]; ok {
+		return url
+	}
+	return layers[0].Layout.SrpmURL(layers[0].Version, fname)
+}
@@ -0,0 +1,101 @@
+package repolib
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rustylynch/go-rpmutils"
+)
+
+// Layouts for the directories source rpms are extracted to
+const (
+	LayoutName = "name"
+	LayoutNVR  = "nvr"
+)
+
+// ParseRpmFilename splits a name-version-release.arch.rpm file name. The
+// name may itself contain dashes, the version and release can not.
+func ParseRpmFilename(filename string) (rpmutils.NEVRA, error) {
+	var nevra rpmutils.NEVRA
+
+	base := strings.TrimSuffix(filename, ".rpm")
+	i := strings.LastIndex(base, ".")
+	if base == filename || i < 0 {
+		return nevra, errors.New("Not an rpm file name: " + filename)
+	}
+	nevra.Arch = base[i+1:]
+
+	fields := strings.Split(base[:i], "-")
+	if len(fields) < 3 {
+		return nevra, errors.New("Not an rpm file name: " + filename)
+	}
+	nevra.Name = strings.Join(fields[:len(fields)-2], "-")
+	nevra.Version = fields[len(fields)-2]
+	nevra.Release = fields[len(fields)-1]
+
+	if nevra.Name == "" || nevra.Version == "" || nevra.Release == "" {
+		return nevra, errors.New("Not an rpm file name: " + filename)
+	}
+	return nevra, nil
+}
+
+// GetSrpmNevraMap maps every source rpm of the version to the name,
+// epoch, version and release recorded for it in the repo
+func GetSrpmNevraMap(version int) (map[string]rpmutils.NEVRA, error) {
+	return getSrpmNevraMap(fmt.Sprintf("%d/srpms/repodata/primary.sqlite",
+		version))
+}
+
+func getSrpmNevraMap(db_path string) (map[string]rpmutils.NEVRA, error) {
+	nmap := make(map[string]rpmutils.NEVRA)
+	db, err := sql.Open("sqlite3", db_path)
+	if err != nil {
+		return nmap, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select location_href, name, epoch, version, " +
+		"release, arch from packages;")
+	if err != nil {
+		return nmap, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var srpm string
+		var nevra rpmutils.NEVRA
+		var epoch sql.NullString
+		err := rows.Scan(&srpm, &nevra.Name, &epoch, &nevra.Version,
+			&nevra.Release, &nevra.Arch)
+		if err != nil {
+			return nmap, err
+		}
+		nevra.Epoch = epoch.String
+		nmap[srpm] = nevra
+	}
+
+	return nmap, rows.Err()
+}
+
+// SourceDir returns the directory name a source rpm is extracted to
+func SourceDir(nevra rpmutils.NEVRA, layout string) (string, error) {
+	switch layout {
+	case LayoutName:
+		return nevra.Name, nil
+	case LayoutNVR:
+		return fmt.Sprintf("%s-%s-%s", nevra.Name, nevra.Version,
+			nevra.Release), nil
+	}
+	return "", errors.New(fmt.Sprintf("Unknown source layout %s", layout))
+}
+
+// SrpmNevra looks the source rpm up in the map from GetSrpmNevraMap and
+// falls back to parsing its file name
+func SrpmNevra(nevras map[string]rpmutils.NEVRA, srpm string) (rpmutils.NEVRA, error) {
+	if nevra, ok := nevras[srpm]; ok {
+		return nevra, nil
+	}
+	return ParseRpmFilename(srpm)
+}
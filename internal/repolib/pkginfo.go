@@ -0,0 +1,152 @@
+package repolib
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type PackageInfo struct {
+	Name          string   `json:"name"`
+	Epoch         string   `json:"epoch,omitempty"`
+	Version       string   `json:"version"`
+	Release       string   `json:"release"`
+	Arch          string   `json:"arch"`
+	Summary       string   `json:"summary"`
+	Description   string   `json:"description"`
+	URL           string   `json:"url"`
+	License       string   `json:"license"`
+	PackageSize   int64    `json:"package_size"`
+	InstalledSize int64    `json:"installed_size"`
+	Provides      []string `json:"provides"`
+	Requires      []string `json:"requires"`
+	SourceRpm     string   `json:"source_rpm"`
+}
+
+var depFlags = map[string]string{
+	"EQ": "=",
+	"LT": "<",
+	"LE": "<=",
+	"GT": ">",
+	"GE": ">=",
+}
+
+// formatDep renders a provides or requires entry the way rpm prints it
+func formatDep(name string, flags, epoch, version, release sql.NullString) string {
+	op, ok := depFlags[flags.String]
+	if !ok || version.String == "" {
+		return name
+	}
+
+	evr := version.String
+	if epoch.String != "" && epoch.String != "0" {
+		evr = epoch.String + ":" + evr
+	}
+	if release.String != "" {
+		evr += "-" + release.String
+	}
+	return fmt.Sprintf("%s %s %s", name, op, evr)
+}
+
+func queryDeps(db *sql.DB, table string, key int) ([]string, error) {
+	rows, err := db.Query("SELECT name, flags, epoch, version, release "+
+		"FROM "+table+" WHERE pkgKey = ?;", key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deps := []string{}
+	for rows.Next() {
+		var name string
+		var flags, epoch, version, release sql.NullString
+		err := rows.Scan(&name, &flags, &epoch, &version, &release)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, formatDep(name, flags, epoch, version, release))
+	}
+	sort.Strings(deps)
+
+	return deps, rows.Err()
+}
+
+// GetPackageInfo looks the named binary packages up in the primary
+// database of the version. Names that are not found are left out.
+func GetPackageInfo(version int, names []string) (map[string]*PackageInfo, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%d/repodata/primary.sqlite",
+		version))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	keys := make(map[int]*PackageInfo)
+	info := make(map[string]*PackageInfo)
+	for len(names) > 0 {
+		n := len(names)
+		if n > queryBatchSize {
+			n = queryBatchSize
+		}
+		batch := names[:n]
+		names = names[n:]
+
+		args := make([]interface{}, len(batch))
+		for i, name := range batch {
+			args[i] = name
+		}
+		rows, err := db.Query(fmt.Sprintf("SELECT pkgKey, name, epoch, "+
+			"version, release, arch, summary, description, url, "+
+			"rpm_license, size_package, size_installed, rpm_sourcerpm "+
+			"FROM packages WHERE name IN (?%s);",
+			strings.Repeat(", ?", len(batch)-1)), args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var key int
+			var p PackageInfo
+			var epoch, summary, description, url, license, srpm sql.NullString
+			var size, installed sql.NullInt64
+			err = rows.Scan(&key, &p.Name, &epoch, &p.Version, &p.Release,
+				&p.Arch, &summary, &description, &url, &license, &size,
+				&installed, &srpm)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if epoch.String != "0" {
+				p.Epoch = epoch.String
+			}
+			p.Summary = summary.String
+			p.Description = description.String
+			p.URL = url.String
+			p.License = license.String
+			p.PackageSize = size.Int64
+			p.InstalledSize = installed.Int64
+			p.SourceRpm = srpm.String
+			keys[key] = &p
+			info[p.Name] = &p
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for key, p := range keys {
+		p.Provides, err = queryDeps(db, "provides", key)
+		if err != nil {
+			return nil, err
+		}
+		p.Requires, err = queryDeps(db, "requires", key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
@@ -7,11 +7,13 @@ import (
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
 	"github.com/intel/clear-linux-dissector/internal/downloader"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -49,34 +51,36 @@ type OpenChecksum struct {
 	Value   string   `xml:",chardata"`
 }
 
-func DownloadRepoInfo(path string, url string) error {
+func DownloadRepoInfo(path string, url string, trust *Trust) error {
 	db := fmt.Sprintf("%s/repodata/primary.sqlite", path)
 	if _, err := os.Stat(db); !os.IsNotExist(err) {
 		// Already downloaded
+		if trust != nil && trust.Keyring != nil {
+			return verifyRepoCache(path, url, trust.Keyring)
+		}
 		return nil
 	}
 
-	config_url := fmt.Sprintf(
-		"%s/repodata/repomd.xml",
-		url)
-
-	resp, err := http.Get(config_url)
+	err := os.MkdirAll(fmt.Sprintf("%s/repodata", path), 0700)
 	if err != nil {
 		return err
-
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	lock, err := downloader.Lock(fmt.Sprintf("%s/repodata/", path))
 	if err != nil {
 		return err
 	}
-	if resp.Status != "200 OK" {
-		return errors.New(fmt.Sprintf("Unable to fetch repo %s",
-			url))
+	defer downloader.Unlock(lock)
+	if _, err := os.Stat(db); !os.IsNotExist(err) {
+		// Downloaded by another process while we waited, which may not
+		// have checked it
+		if trust != nil && trust.Keyring != nil {
+			return verifyRepoCache(path, url, trust.Keyring)
+		}
+		return nil
 	}
 
-	err = os.MkdirAll(fmt.Sprintf("%s/repodata", path), 0700)
+	body, err := saveRepomd(path, url, trust)
 	if err != nil {
 		return err
 	}
@@ -91,130 +95,321 @@ func DownloadRepoInfo(path string, url string) error {
 			url, href)
 
 		if strings.HasSuffix(href, "primary.sqlite.xz") {
-			err := downloader.DownloadFile(db+".xz", url, cs, "")
+			err := downloadDatabase(db, url, cs)
 			if err != nil {
 				return err
 			}
+		}
+	}
 
-			fmt.Printf("Uncompressing %s -> %s\n", db+".xz", db)
+	return nil
+}
 
-			f, err := os.Open(db + ".xz")
-			if err != nil {
-				return err
-			}
-			defer f.Close()
+// saveRepomd fetches repomd.xml of the repo at url into path, the caller
+// holding the lock of its repodata directory
+func saveRepomd(path string, url string, trust *Trust) ([]byte, error) {
+	config_url := fmt.Sprintf(
+		"%s/repodata/repomd.xml",
+		url)
 
-			r, err := xz.NewReader(f)
-			if err != nil {
-				return err
-			}
+	resp, err := http.Get(config_url)
+	if err != nil {
+		return nil, err
 
-			w, err := os.Create(db)
-			if err != nil {
-				return err
-			}
-			defer w.Close()
+	}
+	defer resp.Body.Close()
 
-			if _, err = io.Copy(w, r); err != nil {
-				return err
-			}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != "200 OK" {
+		return nil, errors.New(fmt.Sprintf("Unable to fetch repo %s",
+			url))
+	}
 
-			err = os.Remove(db + ".xz")
-			if err != nil {
-				return nil
-			}
+	// Nothing listed in repomd.xml can be trusted before its signature
+	// has been checked
+	var signature []byte
+	if trust != nil && trust.Keyring != nil {
+		signature, err = fetch(config_url + ".asc")
+		if err != nil {
+			return nil, err
+		}
+		err = verifyRepomd(body, signature, trust.Keyring)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	err = ioutil.WriteFile(fmt.Sprintf("%s/repodata/repomd.xml", path),
+		body, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if signature != nil {
+		err = ioutil.WriteFile(fmt.Sprintf("%s/repodata/repomd.xml.asc", path),
+			signature, 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return body, nil
 }
 
-func DownloadRepo(version int, url string) error {
-	db := fmt.Sprintf("%d/repodata/primary.sqlite", version)
-	if _, err := os.Stat(db); !os.IsNotExist(err) {
-		// Already downloaded
-		return nil
+func downloadDatabase(db string, url string, cs string) error {
+	err := downloader.DownloadFile(db+".xz", url, cs, "")
+	if err != nil {
+		return err
 	}
 
-	// Download package database for binary package repo
-	repo_path := fmt.Sprintf("%d", version)
-	repo_url := fmt.Sprintf(
-		"%s/releases/%d/clear/x86_64/os",
-		url, version)
-	err := DownloadRepoInfo(repo_path, repo_url)
+	fmt.Fprintf(downloader.Progress, "Uncompressing %s -> %s\n", db+".xz", db)
+
+	f, err := os.Open(db + ".xz")
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	err = os.MkdirAll(fmt.Sprintf("%d/source", version), 0700)
+	r, err := xz.NewReader(f)
 	if err != nil {
 		return err
 	}
 
-	err = os.MkdirAll(fmt.Sprintf("%d/srpms", version), 0700)
+	// Readers only look for the final name, so it must not appear
+	// before the database is complete
+	w, err := os.Create(db + ".tmp")
 	if err != nil {
 		return err
 	}
+	defer w.Close()
 
-	// Download package database for source package repo
-	repo_path = fmt.Sprintf("%d/srpms", version)
-	repo_url = fmt.Sprintf(
-		"%s/releases/%d/clear/source/SRPMS",
-		url, version)
-	err = DownloadRepoInfo(repo_path, repo_url)
+	if _, err = io.Copy(w, r); err != nil {
+		return err
+	}
+
+	err = os.Rename(db+".tmp", db)
 	if err != nil {
 		return err
 	}
 
+	err = os.Remove(db + ".xz")
+	if err != nil {
+		return nil
+	}
+
 	return nil
 }
 
-func QueryReqs(version int, requirements map[string]bool, field string) ([]string, error) {
-	db, err := sql.Open("sqlite3",
-		fmt.Sprintf("%d/repodata/primary.sqlite",
-			version))
+// DownloadRepoDatabase fetches one of the other databases listed in the
+// repo metadata DownloadRepoInfo saved for path, "filelists" or "other".
+// Caches from before repomd.xml was kept get it fetched first.
+func DownloadRepoDatabase(path string, url string, kind string, trust *Trust) error {
+	if !exists(path + "/repodata/repomd.xml") {
+		lock, err := downloader.Lock(path + "/repodata/")
+		if err != nil {
+			return err
+		}
+		if !exists(path + "/repodata/repomd.xml") {
+			_, err = saveRepomd(path, url, trust)
+		}
+		downloader.Unlock(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	verify := trust != nil && trust.Keyring != nil
+	if verify {
+		// Authenticates the saved repomd.xml the checksums come from
+		err := verifyRepoCache(path, url, trust.Keyring)
+		if err != nil {
+			return err
+		}
+	}
+
+	content, err := ioutil.ReadFile(path + "/repodata/repomd.xml")
+	if err != nil {
+		return err
+	}
+	var repomd Repomd
+	err = xml.Unmarshal(content, &repomd)
+	if err != nil {
+		return err
+	}
+
+	var data *Data
+	for i := range repomd.Data {
+		if repomd.Data[i].Type == kind+"_db" {
+			data = &repomd.Data[i]
+		}
+	}
+	if data == nil {
+		return errors.New(fmt.Sprintf("Repo %s has no %s database", url, kind))
+	}
+
+	db := fmt.Sprintf("%s/repodata/%s.sqlite", path, kind)
+	lock, err := downloader.Lock(db)
+	if err != nil {
+		return err
+	}
+	defer downloader.Unlock(lock)
+
+	if _, err := os.Stat(db); os.IsNotExist(err) {
+		err = downloadDatabase(db, url+"/"+data.Location.Href,
+			data.Checksum.Value)
+		if err != nil {
+			return err
+		}
+	} else if !verify {
+		return nil
+	}
+
+	if verify {
+		cs, err := downloader.ChecksumFile(db)
+		if err != nil {
+			return err
+		}
+		if cs != data.OpenChecksum.Value {
+			return errors.New(fmt.Sprintf("%s does not match the signed "+
+				"repo metadata", db))
+		}
+	}
+
+	return nil
+}
+
+func binaryRepo(version int, layout *common.Layout) (string, string) {
+	return fmt.Sprintf("%d", version), layout.BinaryRepoURL(version)
+}
+
+func sourceRepo(version int, layout *common.Layout) (string, string) {
+	return fmt.Sprintf("%d/srpms", version), layout.SourceRepoURL(version)
+}
+
+// DownloadBinaryDatabases fetches the named databases of the binary
+// package repo, next to the primary one DownloadRepo fetched
+func DownloadBinaryDatabases(version int, layout *common.Layout, trust *Trust, kinds ...string) error {
+	repo_path, repo_url := binaryRepo(version, layout)
+	for _, kind := range kinds {
+		err := DownloadRepoDatabase(repo_path, repo_url, kind, trust)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DownloadSourceDatabases fetches the named databases of the source
+// package repo
+func DownloadSourceDatabases(version int, layout *common.Layout, trust *Trust, kinds ...string) error {
+	repo_path, repo_url := sourceRepo(version, layout)
+	for _, kind := range kinds {
+		err := DownloadRepoDatabase(repo_path, repo_url, kind, trust)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func DownloadRepo(version int, layout *common.Layout, trust *Trust) error {
+	return DownloadLayers(version, ReleaseLayers(version, layout), trust)
+}
+
+// SQLite limits the number of bound parameters in a single statement
+const queryBatchSize = 500
+
+var queryFields = map[string]bool{
+	"name":          true,
+	"rpm_sourcerpm": true,
+	"location_href": true,
+	"pkgId":         true,
+}
+
+// QueryReqs maps every package field value providing any of the
+// requirements to the requirements it satisfies.
+func QueryReqs(version int, requirements map[string]bool, field string) (map[string][]string, error) {
+	return queryReqs(fmt.Sprintf("%d/repodata/primary.sqlite", version),
+		requirements, field)
+}
+
+func queryReqs(db_path string, requirements map[string]bool, field string) (map[string][]string, error) {
+	if !queryFields[field] {
+		return nil, errors.New(fmt.Sprintf("Unsupported package field %s",
+			field))
+	}
+
+	db, err := sql.Open("sqlite3", db_path)
 	if err != nil {
 		return nil, err
 	}
 	defer db.Close()
 
-	var initial bool = true
-	var b strings.Builder
-	fmt.Fprintf(&b, "SELECT DISTINCT packages.%s FROM packages "+
-		"INNER JOIN provides ON packages.pkgKey=provides.pkgKey",
-		field)
+	var names []string
 	for req := range requirements {
-		if initial == true {
-			fmt.Fprintf(&b, " WHERE provides.name=\"%s\"", req)
-			initial = false
-		} else {
-			fmt.Fprintf(&b, " OR provides.name=\"%s\"", req)
+		names = append(names, req)
+	}
+	sort.Strings(names)
+
+	r := make(map[string][]string)
+	for len(names) > 0 {
+		n := len(names)
+		if n > queryBatchSize {
+			n = queryBatchSize
+		}
+		batch := names[:n]
+		names = names[n:]
+
+		args := make([]interface{}, len(batch))
+		for i, name := range batch {
+			args[i] = name
+		}
+		query := fmt.Sprintf("SELECT DISTINCT packages.%s, provides.name "+
+			"FROM packages INNER JOIN provides "+
+			"ON packages.pkgKey=provides.pkgKey "+
+			"WHERE provides.name IN (?%s);",
+			field, strings.Repeat(", ?", len(batch)-1))
+
+		err = queryProviders(db, query, args, r)
+		if err != nil {
+			return nil, err
 		}
 	}
-	b.WriteString(";")
-	rows, err := db.Query(b.String())
+
+	for provider := range r {
+		sort.Strings(r[provider])
+	}
+	return r, nil
+}
+
+func queryProviders(db *sql.DB, query string, args []interface{}, r map[string][]string) error {
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var r []string
 	for rows.Next() {
-		var value string
-		err := rows.Scan(&value)
+		var value, req string
+		err := rows.Scan(&value, &req)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		r = append(r, value)
+		r[value] = append(r[value], req)
 	}
 
-	return r, nil
+	return rows.Err()
 }
 
 func GetPkgMap(version int) (map[string]string, error) {
+	return getPkgMap(fmt.Sprintf("%d/repodata/primary.sqlite", version))
+}
+
+func getPkgMap(db_path string) (map[string]string, error) {
 	pmap := make(map[string]string)
-	db, err := sql.Open("sqlite3", fmt.Sprintf("%d/repodata/primary.sqlite",
-		version))
+	db, err := sql.Open("sqlite3", db_path)
 	if err != nil {
 		return pmap, err
 	}
@@ -239,9 +434,13 @@ func GetPkgMap(version int) (map[string]string, error) {
 }
 
 func GetSrpmHashMap(version int) (map[string]string, error) {
-	pmap := make(map[string]string)
-	db, err := sql.Open("sqlite3", fmt.Sprintf("%d/srpms/repodata/primary.sqlite",
+	return getSrpmHashMap(fmt.Sprintf("%d/srpms/repodata/primary.sqlite",
 		version))
+}
+
+func getSrpmHashMap(db_path string) (map[string]string, error) {
+	pmap := make(map[string]string)
+	db, err := sql.Open("sqlite3", db_path)
 	if err != nil {
 		return pmap, err
 	}
@@ -265,10 +464,15 @@ func GetSrpmHashMap(version int) (map[string]string, error) {
 	return pmap, nil
 }
 
-func DownloadBundles(clear_version int) error {
+func DownloadBundles(clear_version int, layout *common.Layout, trust *Trust) error {
+	verify := trust != nil && len(trust.Certificates) > 0
+
 	bundle_path := fmt.Sprintf("%d/bundles", clear_version)
 	if _, err := os.Stat(bundle_path + "/.complete"); !os.IsNotExist(err) {
 		// Already downloaded
+		if verify {
+			return verifyBundleCache(clear_version, trust)
+		}
 		return nil
 	}
 
@@ -277,9 +481,29 @@ func DownloadBundles(clear_version int) error {
 		return err
 	}
 
-	config_url := fmt.Sprintf("https://cdn.download.clearlinux.org/"+
-		"update/%d/pack-os-core-update-index-from-0.tar",
-		clear_version)
+	lock, err := downloader.Lock(bundle_path + "/")
+	if err != nil {
+		return err
+	}
+	defer downloader.Unlock(lock)
+	if _, err := os.Stat(bundle_path + "/.complete"); !os.IsNotExist(err) {
+		// Downloaded by another process while we waited
+		if verify {
+			return verifyBundleCache(clear_version, trust)
+		}
+		return nil
+	}
+
+	var hashes map[string]string
+	if verify {
+		hashes, err = fetchBundleHashes(clear_version, layout, trust)
+		if err != nil {
+			return err
+		}
+	}
+
+	config_url := layout.UpdateURL(clear_version) +
+		"/pack-os-core-update-index-from-0.tar"
 
 	resp, err := http.Get(config_url)
 	if err != nil {
@@ -327,7 +551,19 @@ func DownloadBundles(clear_version int) error {
 			continue
 		}
 
-		target := fmt.Sprintf("%d/bundles/%s", clear_version, config["Name"])
+		name, ok := config["Name"].(string)
+		if !ok {
+			continue
+		}
+
+		if verify {
+			err = verifyBundle(hashes, name, content)
+			if err != nil {
+				return err
+			}
+		}
+
+		target := fmt.Sprintf("%d/bundles/%s", clear_version, name)
 		err = ioutil.WriteFile(target, content, 0644)
 		if err != nil {
 			return err
@@ -340,15 +576,18 @@ func DownloadBundles(clear_version int) error {
 	return nil
 }
 
-func GetBundle(clear_version int, name string) (map[string]interface{}, error) {
+func GetBundle(clear_version int, name string, layout *common.Layout, trust *Trust) (map[string]interface{}, error) {
 	var bundle map[string]interface{}
 
-	err := DownloadBundles(clear_version)
+	err := DownloadBundles(clear_version, layout, trust)
 	if err != nil {
 		return bundle, err
 	}
 
 	f, err := os.Open(fmt.Sprintf("%d/bundles/%s", clear_version, name))
+	if os.IsNotExist(err) {
+		return bundle, bundleNotFound(clear_version, name)
+	}
 	if err != nil {
 		return bundle, err
 	}
@@ -367,7 +606,25 @@ func GetBundle(clear_version int, name string) (map[string]interface{}, error) {
 	return bundle, nil
 }
 
-func ExtractRpm(archive string, target string) error {
+// ExtractRpm extracts archive to target, unless the tree there is already
+// complete for the same archive. With force it is extracted again anyway.
+func ExtractRpm(archive string, target string, force bool) error {
+	checksum, err := downloader.ChecksumFile(archive)
+	if err != nil {
+		return err
+	}
+
+	// Another process may be extracting the same archive, so whether the
+	// tree is complete is only known once we hold the lock
+	lock, err := downloader.Lock(target)
+	if err != nil {
+		return err
+	}
+	defer downloader.Unlock(lock)
+	if !force && IsExtracted(target, checksum) {
+		return nil
+	}
+
 	f, err := os.Open(archive)
 	if err != nil {
 		return err
@@ -379,9 +636,72 @@ func ExtractRpm(archive string, target string) error {
 		return err
 	}
 
-	err = rpm.ExpandPayload(target)
+	// Extract into a temporary sibling directory so an interrupted run
+	// never leaves a partial tree at the final location
+	tmp := target + ".tmp"
+	err = os.RemoveAll(tmp)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	err = os.MkdirAll(tmp, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = rpm.ExpandPayload(tmp)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	// The marker of the old tree goes first, so an interrupted run never
+	// leaves a marker beside a tree it does not describe
+	marker := target + ".complete"
+	err = os.Remove(marker)
+	if err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	err = os.RemoveAll(target)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, target)
+	if err != nil {
+		return err
+	}
+
+	// Record the hash of the extracted SRPM beside the tree so a changed
+	// archive can be detected and extracted again
+	return ioutil.WriteFile(marker, []byte(checksum+"\n"), 0644)
+}
+
+func IsExtracted(target string, checksum string) bool {
+	if !exists(target) {
+		return false
+	}
+	content, err := ioutil.ReadFile(target + ".complete")
+	if err != nil {
+		return false
+	}
+
+	if checksum == "" {
+		return true
+	}
+	return strings.TrimSpace(string(content)) == checksum
+}
+
+// RecordOrigin notes the repo of a mix the tree at target was extracted
+// from in target+".repo", keeping the extracted sources untouched
+func RecordOrigin(target string, repo string) error {
+	lock, err := downloader.Lock(target)
+	if err != nil {
+		return err
+	}
+	defer downloader.Unlock(lock)
+
+	return ioutil.WriteFile(target+".repo", []byte(repo+"\n"), 0644)
 }
@@ -0,0 +1,283 @@
+package repolib
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SearchFields are the package fields SearchPackages can match against
+var SearchFields = []string{"name", "summary", "description", "provides"}
+
+type SearchResult struct {
+	Name    string   `json:"name"`
+	Version string   `json:"version"`
+	Release string   `json:"release"`
+	Summary string   `json:"summary"`
+	Matches []string `json:"matches"`
+}
+
+// SearchPackages returns the binary packages of the version with at least
+// one of the given fields matching re, sorted by name. Matches lists the
+// fields that matched.
+func SearchPackages(version int, re *regexp.Regexp, fields []string) ([]SearchResult, error) {
+	search := make(map[string]bool)
+	for _, f := range fields {
+		valid := false
+		for _, known := range SearchFields {
+			if f == known {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, errors.New(fmt.Sprintf("Unknown search field %s", f))
+		}
+		search[f] = true
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%d/repodata/primary.sqlite",
+		version))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	provides := make(map[int][]string)
+	if search["provides"] {
+		rows, err := db.Query("select pkgKey, name from provides;")
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var key int
+			var name string
+			err = rows.Scan(&key, &name)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			provides[key] = append(provides[key], name)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := db.Query("select pkgKey, name, version, release, summary, " +
+		"description from packages;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var key int
+		var r SearchResult
+		var summary, description sql.NullString
+		err = rows.Scan(&key, &r.Name, &r.Version, &r.Release, &summary,
+			&description)
+		if err != nil {
+			return nil, err
+		}
+		r.Summary = summary.String
+
+		for _, f := range SearchFields {
+			if !search[f] {
+				continue
+			}
+			matched := false
+			switch f {
+			case "name":
+				matched = re.MatchString(r.Name)
+			case "summary":
+				matched = re.MatchString(summary.String)
+			case "description":
+				matched = re.MatchString(description.String)
+			case "provides":
+				for _, p := range provides[key] {
+					if re.MatchString(p) {
+						matched = true
+						break
+					}
+				}
+			}
+			if matched {
+				r.Matches = append(r.Matches, f)
+			}
+		}
+		if len(r.Matches) > 0 {
+			results = append(results, r)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	return results, nil
+}
+
+// editDistance is the number of single character insertions, deletions,
+// substitutions and swaps of adjacent characters turning a into b
+func editDistance(a string, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = d[i-1][j-1] + cost
+			if d[i-1][j]+1 < d[i][j] {
+				d[i][j] = d[i-1][j] + 1
+			}
+			if d[i][j-1]+1 < d[i][j] {
+				d[i][j] = d[i][j-1] + 1
+			}
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] &&
+				d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+// ClosestNames returns up to max candidates that look like a misspelling
+// of name or contain it, closest first
+func ClosestNames(name string, candidates []string, max int) []string {
+	lname := strings.ToLower(name)
+	limit := len([]rune(name)) / 3
+	if limit < 1 {
+		limit = 1
+	}
+
+	distances := make(map[string]int)
+	for _, c := range candidates {
+		if c == name {
+			continue
+		}
+		lc := strings.ToLower(c)
+		d := editDistance(lname, lc)
+		if d <= limit || (len(lname) > 2 && strings.Contains(lc, lname)) {
+			distances[c] = d
+		}
+	}
+
+	var closest []string
+	for c := range distances {
+		closest = append(closest, c)
+	}
+	sort.Slice(closest, func(i, j int) bool {
+		a, b := closest[i], closest[j]
+		if distances[a] != distances[b] {
+			return distances[a] < distances[b]
+		}
+		return a < b
+	})
+	if len(closest) > max {
+		closest = closest[:max]
+	}
+	return closest
+}
+
+// Suggest returns a "Did you mean" hint naming the candidates closest to
+// name, or an empty string when none are close
+func Suggest(name string, candidates []string) string {
+	closest := ClosestNames(name, candidates, 3)
+	if len(closest) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Did you mean %s?", strings.Join(closest, ", "))
+}
+
+// AddSuggestion appends the hint of Suggest to msg when some candidates
+// are close to name, ending msg with a period unless it has punctuation
+func AddSuggestion(msg string, name string, candidates []string) string {
+	if s := Suggest(name, candidates); s != "" {
+		if !strings.HasSuffix(msg, "!") && !strings.HasSuffix(msg, ".") &&
+			!strings.HasSuffix(msg, "?") {
+			msg += "."
+		}
+		msg += " " + s
+	}
+	return msg
+}
+
+// SuggestFrom is AddSuggestion for the names in the keys of maps, such as
+// the binary to source package map
+func SuggestFrom(msg string, name string, names ...map[string]string) string {
+	var candidates []string
+	for _, m := range names {
+		for n := range m {
+			candidates = append(candidates, n)
+		}
+	}
+	return AddSuggestion(msg, name, candidates)
+}
+
+func withSuggestion(msg string, name string, candidates []string) error {
+	return errors.New(AddSuggestion(msg, name, candidates))
+}
+
+// PackageNames lists the binary packages in the primary database of the
+// version
+func PackageNames(version int) ([]string, error) {
+	pmap, err := GetPkgMap(version)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range pmap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// BundleNames lists the bundles downloaded for the version
+func BundleNames(version int) ([]string, error) {
+	entries, err := ioutil.ReadDir(fmt.Sprintf("%d/bundles", version))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") || e.IsDir() {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+func bundleNotFound(version int, name string) error {
+	msg := fmt.Sprintf("No bundle %s in version %d", name, version)
+	names, err := BundleNames(version)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return withSuggestion(msg, name, names)
+}
@@ -0,0 +1,84 @@
+package repolib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/rustylynch/go-rpmutils"
+	"golang.org/x/crypto/openpgp"
+)
+
+var ErrUnsigned = errors.New("Package is not signed")
+
+// LoadKeyring reads public keys from an armored or binary keyring file
+func LoadKeyring(path string) (openpgp.EntityList, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(content))
+	if err != nil {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(content))
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to read keyring %s: %s",
+			path, err))
+	}
+
+	return keyring, nil
+}
+
+// VerifyRpm checks that the RPM carries at least one signature and that
+// every signature on it was made by a key from the keyring
+func VerifyRpm(path string, keyring openpgp.EntityList) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, sigs, err := rpmutils.Verify(f, keyring)
+	if err != nil {
+		return errors.New("Bad signature: " + err.Error())
+	}
+
+	if len(sigs) == 0 {
+		return ErrUnsigned
+	}
+
+	return nil
+}
+
+// VerifyRpms checks the signatures of all the RPMs and returns the
+// problems found for each failing one
+func VerifyRpms(paths []string, keyring openpgp.EntityList) map[string]error {
+	failures := make(map[string]error)
+	for _, path := range paths {
+		err := VerifyRpm(path, keyring)
+		if err != nil {
+			failures[path] = err
+		}
+	}
+	return failures
+}
+
+func ReportVerifyFailures(failures map[string]error) {
+	var paths []string
+	for path := range failures {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if failures[path] == ErrUnsigned {
+			fmt.Printf("Unsigned package %s\n", path)
+		} else {
+			fmt.Printf("%s for %s\n", failures[path], path)
+		}
+	}
+}
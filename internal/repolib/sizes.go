@@ -0,0 +1,60 @@
+package repolib
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type RpmSize struct {
+	// Size of the rpm file
+	Package int64 `json:"package_size"`
+	// Size of the files it contains once installed or extracted
+	Installed int64 `json:"installed_size"`
+}
+
+func getSizes(db_path string, key string) (map[string]RpmSize, error) {
+	db, err := sql.Open("sqlite3", db_path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select " + key + ", size_package, " +
+		"size_installed, size_archive from packages;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]RpmSize)
+	for rows.Next() {
+		var name string
+		var size, installed, archive sql.NullInt64
+		err := rows.Scan(&name, &size, &installed, &archive)
+		if err != nil {
+			return nil, err
+		}
+		s := RpmSize{Package: size.Int64, Installed: installed.Int64}
+		// The payload is about as big as the files when that is unknown
+		if !installed.Valid || installed.Int64 == 0 {
+			s.Installed = archive.Int64
+		}
+		sizes[name] = s
+	}
+
+	return sizes, rows.Err()
+}
+
+// GetSrpmSizes reads the sizes of all source rpms of the version from the
+// source repo primary database
+func GetSrpmSizes(version int) (map[string]RpmSize, error) {
+	return getSizes(fmt.Sprintf("%d/srpms/repodata/primary.sqlite", version),
+		"location_href")
+}
+
+// GetPackageSizes reads the sizes of all binary packages of the version
+// from the primary database
+func GetPackageSizes(version int) (map[string]RpmSize, error) {
+	return getSizes(fmt.Sprintf("%d/repodata/primary.sqlite", version),
+		"name")
+}
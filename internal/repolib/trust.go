@@ -0,0 +1,296 @@
+package repolib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/swupd"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// Trust holds the keys used to authenticate downloaded metadata. The
+// keyring verifies repomd.xml.asc and the certificates verify
+// Manifest.MoM.sig. Leaving either empty skips that verification.
+type Trust struct {
+	Keyring      openpgp.EntityList
+	Certificates []*x509.Certificate
+}
+
+const bundleIndex = "os-core-update-index"
+const allBundlesDir = "/usr/share/clear/allbundles/"
+
+func LoadCertificates(path string) ([]*x509.Certificate, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("No certificates found in " + path)
+	}
+	return certs, nil
+}
+
+// LoadTrust returns nil when neither a keyring nor a certificate is given
+func LoadTrust(keyring_path string, cert_path string) (*Trust, error) {
+	if keyring_path == "" && cert_path == "" {
+		return nil, nil
+	}
+
+	var trust Trust
+	var err error
+	if keyring_path != "" {
+		trust.Keyring, err = LoadKeyring(keyring_path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cert_path != "" {
+		trust.Certificates, err = LoadCertificates(cert_path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &trust, nil
+}
+
+func fetch(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Unable to fetch %s: %s",
+			url, resp.Status))
+	}
+
+	return body, nil
+}
+
+func verifyRepomd(repomd []byte, signature []byte, keyring openpgp.EntityList) error {
+	_, err := openpgp.CheckArmoredDetachedSignature(keyring,
+		bytes.NewReader(repomd), bytes.NewReader(signature))
+	if err != nil {
+		return errors.New("Bad signature on repomd.xml: " + err.Error())
+	}
+	return nil
+}
+
+// verifyRepoCache authenticates the cached repomd.xml and checks the
+// cached primary database against it
+func verifyRepoCache(path string, url string, keyring openpgp.EntityList) error {
+	repomd, err := ioutil.ReadFile(path + "/repodata/repomd.xml")
+	if err != nil {
+		return errors.New(fmt.Sprintf("Repo metadata in %s can not be "+
+			"authenticated, remove it and download it again", path))
+	}
+
+	signature, err := ioutil.ReadFile(path + "/repodata/repomd.xml.asc")
+	if err != nil {
+		signature, err = fetch(url + "/repodata/repomd.xml.asc")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = verifyRepomd(repomd, signature, keyring)
+	if err != nil {
+		return err
+	}
+
+	var r Repomd
+	err = xml.Unmarshal(repomd, &r)
+	if err != nil {
+		return err
+	}
+	for _, d := range r.Data {
+		if !strings.HasSuffix(d.Location.Href, "primary.sqlite.xz") {
+			continue
+		}
+		cs, err := downloader.ChecksumFile(path + "/repodata/primary.sqlite")
+		if err != nil {
+			return err
+		}
+		if cs != d.OpenChecksum.Value {
+			return errors.New(fmt.Sprintf("Cached %s/repodata/primary.sqlite "+
+				"does not match the signed repo metadata", path))
+		}
+		return nil
+	}
+
+	return errors.New("Signed repo metadata does not list a primary database")
+}
+
+// bundleHashes authenticates the bundle index and returns the swupd hash
+// of every bundle definition mapped to the bundle name
+func bundleHashes(mom []byte, signature []byte, index []byte, trust *Trust) (map[string]string, error) {
+	err := swupd.VerifySignature(mom, signature, trust.Certificates)
+	if err != nil {
+		return nil, errors.New("Bad signature on Manifest.MoM: " + err.Error())
+	}
+
+	m, err := swupd.ParseManifest(mom)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := m.Find(bundleIndex)
+	if !ok {
+		return nil, errors.New("Manifest.MoM does not list " + bundleIndex)
+	}
+	if swupd.Hash(index, 0100644, 0, 0) != entry.Hash {
+		return nil, errors.New("Manifest." + bundleIndex +
+			" does not match Manifest.MoM")
+	}
+
+	m, err = swupd.ParseManifest(index)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string)
+	for _, e := range m.Entries {
+		// Skip anything that is not a current regular file
+		if !strings.HasPrefix(e.Flags, "F") || strings.Contains(e.Flags, "d") {
+			continue
+		}
+		if !strings.HasPrefix(e.Name, allBundlesDir) {
+			continue
+		}
+		hashes[e.Hash] = path.Base(e.Name)
+	}
+
+	return hashes, nil
+}
+
+// fetchBundleHashes downloads and authenticates the bundle index for the
+// version, keeping a copy with the bundles so the cache can be verified
+// again later on
+func fetchBundleHashes(clear_version int, layout *common.Layout, trust *Trust) (map[string]string, error) {
+	update_url := layout.UpdateURL(clear_version)
+	mom, err := fetch(update_url + "/Manifest.MoM")
+	if err != nil {
+		return nil, err
+	}
+	signature, err := fetch(update_url + "/Manifest.MoM.sig")
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := swupd.ParseManifest(mom)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := m.Find(bundleIndex)
+	if !ok {
+		return nil, errors.New("Manifest.MoM does not list " + bundleIndex)
+	}
+	index, err := fetch(fmt.Sprintf("%s/Manifest.%s",
+		layout.UpdateURL(entry.Version), bundleIndex))
+	if err != nil {
+		return nil, err
+	}
+
+	hashes, err := bundleHashes(mom, signature, index, trust)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle_path := fmt.Sprintf("%d/bundles", clear_version)
+	files := map[string][]byte{
+		".Manifest.MoM":            mom,
+		".Manifest.MoM.sig":        signature,
+		".Manifest." + bundleIndex: index,
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(bundle_path+"/"+name, content, 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return hashes, nil
+}
+
+func verifyBundle(hashes map[string]string, name string, content []byte) error {
+	if hashes[swupd.Hash(content, 0100644, 0, 0)] != name {
+		return errors.New(fmt.Sprintf(
+			"Bundle %s does not match the signed manifest", name))
+	}
+	return nil
+}
+
+// verifyBundleCache authenticates previously downloaded bundles
+func verifyBundleCache(clear_version int, trust *Trust) error {
+	bundle_path := fmt.Sprintf("%d/bundles", clear_version)
+
+	var content [3][]byte
+	for i, name := range []string{".Manifest.MoM", ".Manifest.MoM.sig",
+		".Manifest." + bundleIndex} {
+		var err error
+		content[i], err = ioutil.ReadFile(bundle_path + "/" + name)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Bundles in %s can not be "+
+				"authenticated, remove them and download them again",
+				bundle_path))
+		}
+	}
+
+	hashes, err := bundleHashes(content[0], content[1], content[2], trust)
+	if err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(bundle_path)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".") || f.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(bundle_path + "/" + f.Name())
+		if err != nil {
+			return err
+		}
+		err = verifyBundle(hashes, f.Name(), data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
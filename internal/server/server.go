@@ -0,0 +1,252 @@
+// Package server answers queries about the releases cached in the working
+// directory as a JSON REST API.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server serves the versions it was created with, kept sorted in
+// Versions, which need their repo metadata, file lists and bundles cached
+// already
+type Server struct {
+	Versions []int
+	Layout   *common.Layout
+	Trust    *repolib.Trust
+
+	// Log receives a line for every request
+	Log *log.Logger
+}
+
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &httpError{http.StatusNotFound, fmt.Sprintf(format, args...)}
+}
+
+// notFoundName reports an unknown name along with the closest known ones
+func notFoundName(msg string, name string, names []string) error {
+	return &httpError{http.StatusNotFound,
+		repolib.AddSuggestion(msg, name, names)}
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+// New creates a server for the versions, logging to stderr
+func New(versions []int, layout *common.Layout, trust *repolib.Trust) *Server {
+	s := Server{
+		Versions: append([]int{}, versions...),
+		Layout:   layout,
+		Trust:    trust,
+		Log:      log.New(os.Stderr, "", log.LstdFlags),
+	}
+	sort.Ints(s.Versions)
+	return &s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	status := http.StatusOK
+	var body interface{}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		status = http.StatusMethodNotAllowed
+		body = map[string]string{"error": "Only GET requests are supported"}
+	} else if result, err := s.route(r); err != nil {
+		status = http.StatusInternalServerError
+		if e, ok := err.(*httpError); ok {
+			status = e.status
+		}
+		body = map[string]string{"error": err.Error()}
+	} else {
+		body = result
+	}
+
+	content, err := json.MarshalIndent(body, "", "    ")
+	if err != nil {
+		status = http.StatusInternalServerError
+		content = []byte(`{"error": "Unable to encode the response"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(content, '\n'))
+
+	s.Log.Printf("%s %s %d %s", r.Method, r.URL.RequestURI(), status,
+		time.Since(start))
+}
+
+func (s *Server) route(r *http.Request) (interface{}, error) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "health":
+		return s.health(), nil
+	case len(parts) == 2 && parts[0] == "v1" && parts[1] == "versions":
+		return s.Versions, nil
+	case len(parts) == 2 && parts[0] == "v1" && parts[1] == "diff":
+		return s.diff(r)
+	case len(parts) < 3 || parts[0] != "v1":
+		return nil, notFound("Unknown endpoint %s", r.URL.Path)
+	}
+
+	version, err := s.version(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(parts) == 3 && parts[2] == "bundles":
+		return repolib.BundleNames(version)
+	case len(parts) == 4 && parts[2] == "bundles":
+		return s.bundle(version, parts[3])
+	case len(parts) == 3 && parts[2] == "packages":
+		return repolib.GetPkgMap(version)
+	case len(parts) == 4 && parts[2] == "packages":
+		return s.pkg(version, parts[3])
+	case len(parts) == 3 && parts[2] == "files":
+		return s.files(version, r.URL.Query().Get("path"))
+	case len(parts) == 3 && parts[2] == "licenses":
+		return repolib.GetSrpmLicenses(version)
+	}
+	return nil, notFound("Unknown endpoint %s", r.URL.Path)
+}
+
+// version parses a version of the path, latest being the newest served
+func (s *Server) version(spec string) (int, error) {
+	if spec == "latest" && len(s.Versions) > 0 {
+		return s.Versions[len(s.Versions)-1], nil
+	}
+	v, err := strconv.Atoi(spec)
+	if err != nil {
+		return 0, badRequest("Invalid version %s", spec)
+	}
+	i := sort.SearchInts(s.Versions, v)
+	if i == len(s.Versions) || s.Versions[i] != v {
+		return 0, notFound("Version %d is not served", v)
+	}
+	return v, nil
+}
+
+type health struct {
+	Status   string `json:"status"`
+	Versions []int  `json:"versions"`
+	Missing  []int  `json:"missing,omitempty"`
+}
+
+// health reports the versions whose cached metadata went missing
+func (s *Server) health() *health {
+	h := health{Status: "ok", Versions: s.Versions}
+	for _, v := range s.Versions {
+		for _, db := range []string{"repodata/primary.sqlite",
+			"srpms/repodata/primary.sqlite", "bundles/.complete"} {
+			if _, err := os.Stat(fmt.Sprintf("%d/%s", v, db)); err != nil {
+				h.Status = "degraded"
+				h.Missing = append(h.Missing, v)
+				break
+			}
+		}
+	}
+	return &h
+}
+
+func (s *Server) bundle(version int, name string) (interface{}, error) {
+	names, err := repolib.BundleNames(version)
+	if err != nil {
+		return nil, err
+	}
+	i := sort.SearchStrings(names, name)
+	if i == len(names) || names[i] != name {
+		return nil, notFoundName(fmt.Sprintf("No bundle %s in version %d",
+			name, version), name, names)
+	}
+	return repolib.GetBundle(version, name, s.Layout, s.Trust)
+}
+
+func (s *Server) pkg(version int, name string) (interface{}, error) {
+	info, err := repolib.GetPackageInfo(version, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	if info[name] == nil {
+		names, err := repolib.PackageNames(version)
+		if err != nil {
+			return nil, err
+		}
+		return nil, notFoundName(fmt.Sprintf("No package %s in version %d",
+			name, version), name, names)
+	}
+	return info[name], nil
+}
+
+type fileOwners struct {
+	Path     string   `json:"path"`
+	Packages []string `json:"packages"`
+	Srpms    []string `json:"srpms"`
+}
+
+// files finds the packages, and the source rpms they are built from,
+// shipping the path
+func (s *Server) files(version int, path string) (interface{}, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, badRequest("The path parameter needs an absolute path")
+	}
+	providers, err := repolib.WhatProvides(version, []string{path})
+	if err != nil {
+		return nil, err
+	}
+	pmap, err := repolib.GetPkgMap(version)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := fileOwners{Path: path, Packages: []string{}, Srpms: []string{}}
+	srpms := make(map[string]bool)
+	for _, p := range providers[path] {
+		owners.Packages = append(owners.Packages, p)
+		if pmap[p] != "" && !srpms[pmap[p]] {
+			srpms[pmap[p]] = true
+			owners.Srpms = append(owners.Srpms, pmap[p])
+		}
+	}
+	sort.Strings(owners.Srpms)
+	if len(owners.Packages) == 0 {
+		return nil, notFound("No package ships %s in version %d", path,
+			version)
+	}
+	return &owners, nil
+}
+
+func (s *Server) diff(r *http.Request) (interface{}, error) {
+	var versions [2]int
+	for i, param := range []string{"from", "to"} {
+		spec := r.URL.Query().Get(param)
+		if spec == "" {
+			return nil, badRequest("Missing %s version", param)
+		}
+		v, err := s.version(spec)
+		if err != nil {
+			return nil, err
+		}
+		versions[i] = v
+	}
+	return repolib.ComparePackages(versions[0], versions[1])
+}
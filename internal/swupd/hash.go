@@ -0,0 +1,31 @@
+// Package swupd implements the parts of the swupd update content format
+// needed to authenticate bundle metadata: file hashes, manifests and the
+// signature on Manifest.MoM.
+package swupd
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+func hmacSha256(key []byte, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return []byte(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Hash computes the swupd hash of a regular file. The file metadata is
+// folded into the HMAC key the same way swupd does it.
+func Hash(content []byte, mode, uid, gid uint32) string {
+	var stat [40]byte
+	binary.LittleEndian.PutUint64(stat[0:], uint64(mode))
+	binary.LittleEndian.PutUint64(stat[8:], uint64(uid))
+	binary.LittleEndian.PutUint64(stat[16:], uint64(gid))
+	binary.LittleEndian.PutUint64(stat[24:], 0)
+	binary.LittleEndian.PutUint64(stat[32:], uint64(len(content)))
+
+	key := hmacSha256(stat[:], nil)
+	return string(hmacSha256(key, content))
+}
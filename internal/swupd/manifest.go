@@ -0,0 +1,85 @@
+package swupd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type ManifestEntry struct {
+	Flags   string
+	Hash    string
+	Version int
+	Name    string
+}
+
+type Manifest struct {
+	Format  int
+	Version int
+	Header  map[string]string
+	Entries []ManifestEntry
+}
+
+// ParseManifest reads a swupd manifest, including Manifest.MoM
+func ParseManifest(content []byte) (*Manifest, error) {
+	m := &Manifest{Header: make(map[string]string)}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	if !scanner.Scan() {
+		return nil, errors.New("Empty manifest")
+	}
+	_, err := fmt.Sscanf(scanner.Text(), "MANIFEST\t%d", &m.Format)
+	if err != nil {
+		return nil, errors.New("Not a swupd manifest")
+	}
+
+	inHeader := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inHeader {
+			if line == "" {
+				inHeader = false
+				continue
+			}
+			tokens := strings.SplitN(line, ":", 2)
+			if len(tokens) != 2 {
+				return nil, errors.New("Corrupt manifest header: " + line)
+			}
+			m.Header[tokens[0]] = strings.TrimSpace(tokens[1])
+			continue
+		}
+
+		tokens := strings.Split(line, "\t")
+		if len(tokens) != 4 {
+			return nil, errors.New("Corrupt manifest entry: " + line)
+		}
+		version, err := strconv.Atoi(tokens[2])
+		if err != nil {
+			return nil, errors.New("Corrupt manifest entry: " + line)
+		}
+		m.Entries = append(m.Entries, ManifestEntry{
+			Flags:   tokens[0],
+			Hash:    tokens[1],
+			Version: version,
+			Name:    tokens[3],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	m.Version, _ = strconv.Atoi(m.Header["version"])
+	return m, nil
+}
+
+func (m *Manifest) Find(name string) (ManifestEntry, bool) {
+	for _, e := range m.Entries {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return ManifestEntry{}, false
+}
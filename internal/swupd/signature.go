@@ -0,0 +1,205 @@
+package swupd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+)
+
+// Manifest.MoM.sig is a detached, DER encoded PKCS#7 signature as created
+// by openssl smime
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+
+	oidRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+)
+
+var digestAlgorithms = map[string]crypto.Hash{
+	"1.3.14.3.2.26":          crypto.SHA1,
+	"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+	"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+	"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+}
+
+var rsaAlgorithms = map[crypto.Hash]x509.SignatureAlgorithm{
+	crypto.SHA1:   x509.SHA1WithRSA,
+	crypto.SHA256: x509.SHA256WithRSA,
+	crypto.SHA384: x509.SHA384WithRSA,
+	crypto.SHA512: x509.SHA512WithRSA,
+}
+
+var ecdsaAlgorithms = map[crypto.Hash]x509.SignatureAlgorithm{
+	crypto.SHA1:   x509.ECDSAWithSHA1,
+	crypto.SHA256: x509.ECDSAWithSHA256,
+	crypto.SHA384: x509.ECDSAWithSHA384,
+	crypto.SHA512: x509.ECDSAWithSHA512,
+}
+
+type ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      ContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []SignerInfo  `asn1:"set"`
+}
+
+type IssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type SignerInfo struct {
+	Version                   int
+	IssuerAndSerial           IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+func findSigner(info SignerInfo, certs []*x509.Certificate) *x509.Certificate {
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, info.IssuerAndSerial.Issuer.FullBytes) &&
+			c.SerialNumber.Cmp(info.IssuerAndSerial.Serial) == 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+func signedContent(info SignerInfo, content []byte, hash crypto.Hash) ([]byte, error) {
+	if len(info.AuthenticatedAttributes.FullBytes) == 0 {
+		return content, nil
+	}
+
+	// The signature covers the attributes encoded as a SET rather than
+	// with the implicit tag used inside SignerInfo
+	signed := append([]byte{}, info.AuthenticatedAttributes.FullBytes...)
+	signed[0] = 0x31
+
+	var attrs []Attribute
+	_, err := asn1.UnmarshalWithParams(signed, &attrs, "set")
+	if err != nil {
+		return nil, err
+	}
+
+	h := hash.New()
+	h.Write(content)
+	for _, attr := range attrs {
+		if !attr.Type.Equal(oidMessageDigest) {
+			continue
+		}
+		var digest []byte
+		_, err := asn1.Unmarshal(attr.Values.Bytes, &digest)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(digest, h.Sum(nil)) {
+			return nil, errors.New("Signed content digest mismatch")
+		}
+		return signed, nil
+	}
+
+	return nil, errors.New("Signature has no message digest")
+}
+
+// VerifySignature checks a detached PKCS#7 signature over content. The
+// signer must chain up to one of the trusted certificates.
+func VerifySignature(content []byte, signature []byte, trusted []*x509.Certificate) error {
+	var ci ContentInfo
+	_, err := asn1.Unmarshal(signature, &ci)
+	if err != nil {
+		return errors.New("Corrupt signature: " + err.Error())
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return errors.New("Signature does not hold signed data")
+	}
+
+	var sd SignedData
+	_, err = asn1.Unmarshal(ci.Content.Bytes, &sd)
+	if err != nil {
+		return errors.New("Corrupt signature: " + err.Error())
+	}
+	if len(sd.SignerInfos) == 0 {
+		return errors.New("Signature has no signers")
+	}
+
+	var embedded []*x509.Certificate
+	if len(sd.Certificates.Bytes) > 0 {
+		embedded, err = x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	roots := x509.NewCertPool()
+	for _, c := range trusted {
+		roots.AddCert(c)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range embedded {
+		intermediates.AddCert(c)
+	}
+
+	for _, info := range sd.SignerInfos {
+		signer := findSigner(info, append(embedded, trusted...))
+		if signer == nil {
+			return errors.New("Signing certificate not found")
+		}
+
+		_, err = signer.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return err
+		}
+
+		hash, ok := digestAlgorithms[info.DigestAlgorithm.Algorithm.String()]
+		if !ok {
+			return errors.New("Unsupported signature digest")
+		}
+
+		var algo x509.SignatureAlgorithm
+		switch {
+		case info.DigestEncryptionAlgorithm.Algorithm.Equal(oidRSA):
+			algo = rsaAlgorithms[hash]
+		case info.DigestEncryptionAlgorithm.Algorithm.Equal(oidECDSA):
+			algo = ecdsaAlgorithms[hash]
+		default:
+			return errors.New("Unsupported signature algorithm")
+		}
+
+		signed, err := signedContent(info, content, hash)
+		if err != nil {
+			return err
+		}
+
+		err = signer.CheckSignature(algo, signed, info.EncryptedDigest)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,1343 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/fakecdn"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var commands = []string{
+	"bundles2files",
+	"bundles2packages",
+	"cache",
+	"changelog",
+	"depgraph",
+	"dissector",
+	"downloadpackages",
+	"downloadrepo",
+	"explain",
+	"image2bundles",
+	"mirror",
+	"packages2source",
+	"pkginfo",
+	"search",
+	"server",
+	"size",
+	"whatprovides",
+	"whatrequires",
+}
+
+var binDir string
+
+func TestMain(m *testing.M) {
+	if target := os.Getenv("DISSECTOR_TEST_LOCK"); target != "" {
+		holdLock(target, os.Getenv("DISSECTOR_TEST_CHECKSUM"))
+	}
+
+	var err error
+	binDir, err = ioutil.TempDir("", "dissector-bin")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	for _, c := range commands {
+		out, err := exec.Command("go", "build", "-o",
+			filepath.Join(binDir, c),
+			"github.com/intel/clear-linux-dissector/cmd/"+c).CombinedOutput()
+		if err != nil {
+			fmt.Printf("Unable to build %s: %s\n%s", c, err, out)
+			os.RemoveAll(binDir)
+			os.Exit(1)
+		}
+	}
+
+	res := m.Run()
+	os.RemoveAll(binDir)
+	os.Exit(res)
+}
+
+func run(t *testing.T, name string, args ...string) string {
+	cmd := exec.Command(filepath.Join(binDir, name), args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s %s failed: %s\n%s", name, strings.Join(args, " "),
+			err, out)
+	}
+	return string(out)
+}
+
+func sortedLines(out string) []string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestImage2Bundles(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	version := fmt.Sprint(testVersion)
+	out := run(t, "image2bundles", "-v", version, "-n", "kvm",
+		"-u", cdn.URL+"/releases")
+	if fmt.Sprint(sortedLines(out)) != "[kernel-kvm os-core os-core-update]" {
+		t.Fatalf("Unexpected bundles for kvm image: %q", out)
+	}
+
+	out = run(t, "image2bundles", "-v", version, "-l",
+		"-u", cdn.URL+"/releases")
+	if fmt.Sprint(sortedLines(out)) != "[kvm live-server]" {
+		t.Fatalf("Unexpected image list: %q", out)
+	}
+
+	out = run(t, "image2bundles", "-v", version, "-n", "kvm", "-j",
+		"-u", cdn.URL+"/releases")
+	var config map[string]interface{}
+	err := json.Unmarshal([]byte(out), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["Language"] != "en_US.UTF-8" || config["Version"] != version {
+		t.Fatalf("Image definition not printed as published: %q", out)
+	}
+	if _, ok := config["Kernel"]; ok {
+		t.Fatalf("Image definition has keys not in the file: %q", out)
+	}
+	image, err := repolib.ParseImage([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.Kernel() != "kernel-kvm" || len(image.PartitionLayout()) != 2 ||
+		image.PostNonChroot()[0] != "config/image/kvm-post.sh" ||
+		image.Version() != version {
+		t.Fatalf("Unexpected image definition: %q", out)
+	}
+
+	err = ioutil.WriteFile("local-config.json",
+		[]byte(`{"Bundles": ["os-core", "editors"]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = run(t, "image2bundles", "-f", "local-config.json")
+	if fmt.Sprint(sortedLines(out)) != "[editors os-core]" {
+		t.Fatalf("Unexpected bundles for local image: %q", out)
+	}
+
+	// Missing names and bundle lists are reported instead of crashing
+	err = ioutil.WriteFile("empty-config.json", []byte(`{}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-v", version, "-u", cdn.URL + "/releases"},
+		{"-f", "empty-config.json"},
+		{"-v", version, "-n", "missing", "-u", cdn.URL + "/releases"},
+	} {
+		out, err := exec.Command(filepath.Join(binDir, "image2bundles"),
+			args...).CombinedOutput()
+		if err == nil || strings.Contains(string(out), "panic") {
+			t.Fatalf("image2bundles %v did not fail cleanly: %s", args, out)
+		}
+	}
+}
+
+func TestBundlesToPackagesAndSource(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	version := fmt.Sprint(testVersion)
+	run(t, "downloadrepo", "-clear_version", version, "-url", cdn.URL)
+
+	out := run(t, "bundles2packages", "-clear_version", version,
+		"-repo_url", cdn.URL, "os-core")
+	expected := "[bash glibc-bin libc6 ncurses-lib]"
+	if fmt.Sprint(sortedLines(out)) != expected {
+		t.Fatalf("Unexpected packages for os-core: %q", out)
+	}
+
+	out = run(t, "bundles2files", "-clear_version", version,
+		"-repo_url", cdn.URL, "os-core-update")
+	if strings.TrimSpace(out) != "/usr/lib64/libz.so.1" {
+		t.Fatalf("Unexpected files for os-core-update: %q", out)
+	}
+
+	out = run(t, "packages2source", "-clear_version", version,
+		"-repo_url", cdn.URL, "bash")
+	expected = fmt.Sprintf("%s/releases/%d/clear/source/SRPMS/bash-4.4-50.src.rpm",
+		cdn.URL, testVersion)
+	if strings.TrimSpace(out) != expected {
+		t.Fatalf("Unexpected source URL for bash: %q", out)
+	}
+
+	cmd := exec.Command(filepath.Join(binDir, "packages2source"),
+		"-clear_version", version, "-repo_url", cdn.URL, "bsah")
+	out_bytes, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out_bytes), "Did you mean bash?") {
+		t.Fatalf("Expected a suggestion for a misspelled package: %s", out_bytes)
+	}
+
+	cmd = exec.Command(filepath.Join(binDir, "bundles2packages"),
+		"-clear_version", version, "-repo_url", cdn.URL, "os-cor")
+	out_bytes, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out_bytes),
+		"Did you mean os-core, os-core-update?") {
+		t.Fatalf("Expected a suggestion for a misspelled bundle: %s", out_bytes)
+	}
+}
+
+func TestDownloadPackages(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	run(t, "downloadpackages", "-clear_version", fmt.Sprint(testVersion),
+		"-url", cdn.URL, "zlib-lib")
+	target := fmt.Sprintf("%d/source/zlib-1.2.11-30.src.rpm", testVersion)
+	if _, err := os.Stat(target); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDissector(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	version := fmt.Sprint(testVersion)
+	run(t, "dissector", "-clear_version", version, "-repo_url", cdn.URL,
+		"os-core")
+
+	for _, src := range []string{"bash", "glibc", "ncurses"} {
+		target := fmt.Sprintf("%d/source/%s.complete", testVersion, src)
+		if _, err := os.Stat(target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(version + "/source/zlib"); !os.IsNotExist(err) {
+		t.Fatal("zlib is not part of os-core but was extracted")
+	}
+
+	// A second run must reuse the extracted trees
+	out := run(t, "dissector", "-clear_version", version, "-repo_url",
+		cdn.URL, "os-core")
+	if strings.Contains(out, "Extracting") {
+		t.Fatalf("Complete trees were extracted again: %q", out)
+	}
+
+	// A tree without its marker is incomplete, a marker with another
+	// checksum belongs to an older SRPM, both are extracted again while
+	// up to date trees are kept as they are
+	source := version + "/source/"
+	for _, file := range []string{"bash/partial", "glibc/stale", "ncurses/kept"} {
+		if err := ioutil.WriteFile(source+file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(source + "bash.complete"); err != nil {
+		t.Fatal(err)
+	}
+	err := ioutil.WriteFile(source+"glibc.complete", []byte("0123abcd\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = run(t, "dissector", "-clear_version", version, "-repo_url",
+		cdn.URL, "os-core")
+	for _, tree := range []string{"bash", "glibc"} {
+		if !strings.Contains(out, "to "+source+tree+"...") {
+			t.Fatalf("%s was not extracted again: %q", tree, out)
+		}
+	}
+	if strings.Contains(out, source+"ncurses...") {
+		t.Fatalf("ncurses was extracted again: %q", out)
+	}
+	for file, kept := range map[string]bool{
+		"bash/partial": false,
+		"glibc/stale":  false,
+		"ncurses/kept": true,
+	} {
+		if _, err := os.Stat(source + file); os.IsNotExist(err) == kept {
+			t.Fatalf("Unexpected state of %s after extraction", file)
+		}
+	}
+	for _, tree := range []string{"bash", "glibc"} {
+		content, err := ioutil.ReadFile(source + tree + ".complete")
+		if err != nil || strings.TrimSpace(string(content)) == "0123abcd" {
+			t.Fatalf("%s was not marked as extracted again", tree)
+		}
+	}
+}
+
+func TestDissectorPlan(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	version := fmt.Sprint(testVersion)
+	out := run(t, "dissector", "-clear_version", version, "-repo_url", cdn.URL,
+		"-plan", "os-core")
+	for _, expected := range []string{
+		"download bash-4.4-50.src.rpm (",
+		"extract glibc-2.27-187.src.rpm to " + version + "/source/glibc (",
+		"Source rpms: 3, 3 to download (",
+		"Source trees: 3 to extract (",
+		"Disk space needed in .: ",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("Expected %q in the plan: %s", expected, out)
+		}
+	}
+	if _, err := os.Stat(version + "/srpms/bash-4.4-50.src.rpm"); !os.IsNotExist(err) {
+		t.Fatal("Planning downloaded source rpms")
+	}
+
+	run(t, "dissector", "-clear_version", version, "-repo_url", cdn.URL,
+		"os-core")
+	out = run(t, "dissector", "-clear_version", version, "-repo_url", cdn.URL,
+		"-plan", "os-core")
+	for _, expected := range []string{
+		"cached ncurses-6.1-28.src.rpm",
+		"up to date " + version + "/source/ncurses",
+		"Source rpms: 3, 0 to download (0 B), 3 cached",
+		"Source trees: 0 to extract (0 B), 3 up to date",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("Expected %q in the plan: %s", expected, out)
+		}
+	}
+
+	out = run(t, "dissector", "-clear_version", version, "-repo_url", cdn.URL,
+		"-plan", "-export", "os-core.tar", "os-core")
+	if !strings.Contains(out, "Archive: os-core.tar (") ||
+		strings.Contains(out, "extract") {
+		t.Fatalf("Unexpected export plan: %s", out)
+	}
+	if _, err := os.Stat("os-core.tar"); !os.IsNotExist(err) {
+		t.Fatal("Planning wrote the archive")
+	}
+}
+
+func TestDissectorLayout(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	version := fmt.Sprint(testVersion)
+	run(t, "dissector", "-clear_version", version, "-repo_url", cdn.URL,
+		"-layout", "nvr", "os-core")
+
+	for _, src := range []string{"bash-4.4-50", "glibc-2.27-187", "ncurses-6.1-28"} {
+		target := fmt.Sprintf("%d/source/%s.complete", testVersion, src)
+		if _, err := os.Stat(target); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run(t, "cache", "verify")
+}
+
+func TestBinaryRpms(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	version := fmt.Sprint(testVersion)
+	out := run(t, "dissector", "-clear_version", version, "-repo_url", cdn.URL,
+		"-binary", "-plan", "os-core")
+	for _, expected := range []string{
+		"download bash-4.4-50.x86_64.rpm (",
+		"extract glibc-bin-2.27-187.x86_64.rpm to " + version + "/binary/glibc-bin (",
+		"Binary rpms: 4, 4 to download (",
+		"Binary trees: 4 to extract (",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("Expected %q in the plan: %s", expected, out)
+		}
+	}
+
+	run(t, "dissector", "-clear_version", version, "-repo_url", cdn.URL,
+		"-binary", "os-core")
+	for _, file := range []string{
+		"rpms/libc6-2.27-187.x86_64.rpm",
+		"binary/bash.complete",
+		"binary/bash/usr/bin/bash",
+		"binary/ncurses-lib/usr/lib64/libncursesw.so.6",
+	} {
+		if _, err := os.Stat(version + "/" + file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(version + "/source/bash"); !os.IsNotExist(err) {
+		t.Fatal("Binary mode extracted the source rpms")
+	}
+
+	run(t, "downloadpackages", "-clear_version", version, "-url", cdn.URL,
+		"-binary", "zlib-lib")
+	target := version + "/rpms/zlib-lib-1.2.11-30.x86_64.rpm"
+	if _, err := os.Stat(target); err != nil {
+		t.Fatal(err)
+	}
+
+	exported, err := exec.Command(filepath.Join(binDir, "dissector"),
+		"-clear_version", version, "-repo_url", cdn.URL, "-binary",
+		"-export", "os-core.tar", "os-core").CombinedOutput()
+	if err == nil {
+		t.Fatalf("Exporting binary rpms did not fail: %s", exported)
+	}
+}
+
+func TestDebugRpms(t *testing.T) {
+	release := fakecdn.Sample(testVersion)
+	release.DebugPackages = append(release.DebugPackages,
+		fakecdn.Package{Name: "bash-dbg", Version: "4.4", Release: "50",
+			Source: "bash", License: "GPL-3.0",
+			Files: map[string]string{
+				"usr/lib/debug/usr/bin/bash.debug": "symbols",
+				"usr/src/debug/bash-4.4/shell.c":   "int main() {}\n",
+			}},
+		fakecdn.Package{Name: "glibc-dbg", Version: "2.27", Release: "187",
+			Source: "glibc", License: "LGPL-2.1",
+			Files: map[string]string{
+				"usr/src/debug/glibc-2.27/elf/ldd.c": "ldd\n",
+			}},
+		// Debuginfo of another build must not be used
+		fakecdn.Package{Name: "ncurses-dbg", Version: "6.1", Release: "27",
+			Source: "ncurses", License: "MIT",
+			Files: map[string]string{
+				"usr/src/debug/ncurses-6.1/tty.c": "tty\n",
+			}})
+	cdn, cleanup := startCDN(t, release)
+	defer cleanup()
+
+	version := fmt.Sprint(testVersion)
+	out := run(t, "dissector", "-clear_version", version, "-repo_url", cdn.URL,
+		"-debug", "os-core")
+	if !strings.Contains(out, "No debuginfo found for ncurses-lib") {
+		t.Fatalf("Expected ncurses-lib to lack debuginfo: %s", out)
+	}
+	for _, file := range []string{
+		"debuginfo/repodata/primary.sqlite",
+		"rpms/glibc-dbg-2.27-187.x86_64.rpm",
+		"debug/bash-dbg.complete",
+		"debug/bash-dbg/usr/src/debug/bash-4.4/shell.c",
+		"debug/glibc-dbg/usr/src/debug/glibc-2.27/elf/ldd.c",
+	} {
+		if _, err := os.Stat(version + "/" + file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(version + "/debug/ncurses-dbg"); !os.IsNotExist(err) {
+		t.Fatal("Debuginfo of another ncurses release was extracted")
+	}
+
+	index, err := ioutil.ReadFile(version + "/debug/INDEX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "bash-4.4-50.x86_64\tbash-dbg\n" +
+		"glibc-bin-2.27-187.x86_64\tglibc-dbg\n" +
+		"libc6-2.27-187.x86_64\tglibc-dbg\n"
+	if string(index) != expected {
+		t.Fatalf("Unexpected debug index: %q", index)
+	}
+
+	// Debuginfo can be published elsewhere
+	err = os.Rename(filepath.Join(cdn.Root, "releases", version, "clear",
+		"x86_64", "debug"), filepath.Join(cdn.Root, "debuginfo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile("layout.json",
+		[]byte(`{"debug_repo": "{url}/debuginfo"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.RemoveAll(version + "/debuginfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, "dissector", "-clear_version", version, "-repo_url", cdn.URL,
+		"-repo_layout", "layout.json", "-debug", "-reextract", "os-core")
+	if _, err := os.Stat(version + "/debuginfo/repodata/primary.sqlite"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(version + "/debug/glibc-dbg/usr/src/debug/glibc-2.27/elf/ldd.c"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoLayout(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	// Rearrange the release the way a mixer derivative might publish it
+	mix := filepath.Join(cdn.Root, "mix", fmt.Sprint(testVersion))
+	err := os.MkdirAll(filepath.Dir(mix), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(filepath.Join(cdn.Root, "releases",
+		fmt.Sprint(testVersion), "clear"), mix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(filepath.Join(cdn.Root, "update", fmt.Sprint(testVersion)),
+		filepath.Join(mix, "update"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layout := `{
+    "binary_repo": "{url}/mix/{version}/{arch}/os",
+    "source_repo": "{url}/mix/{version}/source/SRPMS",
+    "update": "{url}/mix/{version}/update",
+    "images": "{url}/mix/{version}/config/image",
+    "releases": "{url}/mix"
+}`
+	err = ioutil.WriteFile("layout.json", []byte(layout), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(filepath.Join(binDir, "downloadrepo"),
+		"-clear_version", fmt.Sprint(testVersion), "-url", cdn.URL,
+		"-repo_layout", "layout.json", "-arch", "aarch64")
+	if out, err := cmd.CombinedOutput(); err == nil {
+		t.Fatalf("Expected no aarch64 repo in the mix: %s", out)
+	}
+	os.RemoveAll(fmt.Sprint(testVersion))
+
+	run(t, "downloadrepo", "-clear_version", "latest-0", "-url", cdn.URL,
+		"-repo_layout", "layout.json")
+	out := run(t, "bundles2packages", "-clear_version", "latest-0",
+		"-repo_url", cdn.URL, "-repo_layout", "layout.json", "os-core")
+	if fmt.Sprint(sortedLines(out)) != "[bash glibc-bin libc6 ncurses-lib]" {
+		t.Fatalf("Unexpected packages for os-core: %q", out)
+	}
+
+	out = run(t, "packages2source", "-clear_version", fmt.Sprint(testVersion),
+		"-repo_url", cdn.URL, "-repo_layout", "layout.json", "bash")
+	expected := fmt.Sprintf("%s/mix/%d/source/SRPMS/bash-4.4-50.src.rpm",
+		cdn.URL, testVersion)
+	if strings.TrimSpace(out) != expected {
+		t.Fatalf("Unexpected source URL for bash: %q", out)
+	}
+
+	run(t, "downloadpackages", "-clear_version", fmt.Sprint(testVersion),
+		"-url", cdn.URL, "-repo_layout", "layout.json", "zlib-lib")
+	target := fmt.Sprintf("%d/source/zlib-1.2.11-30.src.rpm", testVersion)
+	if _, err := os.Stat(target); err != nil {
+		t.Fatal(err)
+	}
+
+	out = run(t, "image2bundles", "-v", fmt.Sprint(testVersion),
+		"-u", cdn.URL+"/releases", "-repo_layout", "layout.json", "-l")
+	if fmt.Sprint(sortedLines(out)) != "[kvm live-server]" {
+		t.Fatalf("Unexpected images: %q", out)
+	}
+}
+
+func TestSymbolicVersions(t *testing.T) {
+	cdn, cleanup := startCDN(t, fakecdn.Sample(testVersion),
+		fakecdn.Sample(testVersion+10))
+	defer cleanup()
+
+	run(t, "downloadrepo", "-clear_version", "latest-1", "-url", cdn.URL)
+	if _, err := os.Stat(fmt.Sprintf("%d/repodata/primary.sqlite",
+		testVersion)); err != nil {
+		t.Fatal(err)
+	}
+
+	out := run(t, "image2bundles", "-v", "latest", "-u", cdn.URL+"/releases",
+		"-n", "kvm")
+	if !strings.Contains(out, "kernel-kvm") {
+		t.Fatalf("Unexpected bundles for the latest kvm image: %s", out)
+	}
+}
+
+func TestDissectorKeyring(t *testing.T) {
+	signer, err := fakecdn.NewSigner("Clear Linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := fakecdn.Sample(testVersion)
+	release.Signer = signer
+	cdn, cleanup := startCDN(t, release, fakecdn.Sample(testVersion+10))
+	defer cleanup()
+
+	err = fakecdn.WritePublicKey(signer, "clear.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, "dissector", "-clear_version", fmt.Sprint(testVersion),
+		"-repo_url", cdn.URL, "-keyring", "clear.asc", "os-core")
+
+	out, err := exec.Command(filepath.Join(binDir, "downloadpackages"),
+		"-clear_version", fmt.Sprint(testVersion+10), "-url", cdn.URL,
+		"-keyring", "clear.asc", "bash").CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "Unsigned package") {
+		t.Fatalf("Unsigned package was not reported: %s", out)
+	}
+}
+
+func TestCache(t *testing.T) {
+	cdn, cleanup := startCDN(t, fakecdn.Sample(testVersion),
+		fakecdn.Sample(testVersion+10))
+	defer cleanup()
+
+	for _, v := range []int{testVersion, testVersion + 10} {
+		run(t, "dissector", "-clear_version", fmt.Sprint(v), "-repo_url",
+			cdn.URL, "os-core")
+	}
+
+	out := run(t, "cache", "list")
+	for _, expected := range []string{fmt.Sprint(testVersion),
+		fmt.Sprint(testVersion + 10), "sources: 3 extracted, 0 incomplete"} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("Expected %q in cache list: %s", expected, out)
+		}
+	}
+
+	run(t, "cache", "verify")
+
+	// A corrupted download and leftovers from interrupted runs
+	srpm := fmt.Sprintf("%d/srpms/bash-4.4-50.src.rpm", testVersion)
+	err := ioutil.WriteFile(srpm, []byte("corrupt"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leftovers := []string{
+		fmt.Sprintf("%d/srpms/zlib-1.2.11-30.src.rpm.tmp", testVersion),
+		fmt.Sprintf("%d/source/zlib.tmp/zlib.c", testVersion),
+	}
+	for _, path := range leftovers {
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Trees extracted before markers were written are kept
+	legacy := fmt.Sprintf("%d/source/zlib/zlib.c", testVersion)
+	if err = os.MkdirAll(filepath.Dir(legacy), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(legacy, []byte("legacy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	verify, err := exec.Command(filepath.Join(binDir, "cache"),
+		"verify").CombinedOutput()
+	if err == nil || !strings.Contains(string(verify), "Checksum mismatch for "+srpm) {
+		t.Fatalf("Corrupted source rpm was not reported: %s", verify)
+	}
+
+	// A download still running in another process is skipped, and so is
+	// a database uncompressed under the lock of its repodata directory
+	lock, err := downloader.Lock(strings.TrimSuffix(leftovers[0], ".tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := fmt.Sprintf("%d/repodata/primary.sqlite.tmp", testVersion)
+	if err = ioutil.WriteFile(primary, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repodata, err := downloader.Lock(fmt.Sprintf("%d/repodata/", testVersion))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = run(t, "cache", "gc")
+	for _, path := range []string{leftovers[0], primary} {
+		if !strings.Contains(out, "Skipping "+path) {
+			t.Fatalf("Locked %s was not skipped: %s", path, out)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("Locked %s was removed by gc", path)
+		}
+	}
+	downloader.Unlock(lock)
+	downloader.Unlock(repodata)
+	leftovers = append(leftovers, primary)
+
+	run(t, "cache", "gc")
+	for _, path := range leftovers {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s was not removed", path)
+		}
+	}
+	if _, err := os.Stat(fmt.Sprintf("%d/source/bash.complete",
+		testVersion)); err != nil {
+		t.Fatal("Complete tree was removed by gc")
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		t.Fatal("Tree without a marker was removed by gc")
+	}
+
+	// So is a version another process holds a lock in
+	lock, err = downloader.Lock(fmt.Sprintf("%d/srpms/bash-4.4-50.src.rpm",
+		testVersion))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = run(t, "cache", "-keep", "1", "prune")
+	if !strings.Contains(out, "Skipping "+fmt.Sprint(testVersion)) {
+		t.Fatalf("Locked version was not skipped: %s", out)
+	}
+	downloader.Unlock(lock)
+
+	out = run(t, "cache", "-keep", "1", "-n", "prune")
+	if !strings.Contains(out, "Would remove "+fmt.Sprint(testVersion)) {
+		t.Fatalf("Unexpected prune output: %s", out)
+	}
+	run(t, "cache", "-keep", "1", "prune")
+	if _, err := os.Stat(fmt.Sprint(testVersion)); !os.IsNotExist(err) {
+		t.Fatal("Old version was not pruned")
+	}
+	if _, err := os.Stat(fmt.Sprint(testVersion + 10)); err != nil {
+		t.Fatal("Newest version was pruned")
+	}
+}
+
+func TestDissectorExport(t *testing.T) {
+	// libc6 is also provided by a package built from another source rpm,
+	// the index has to map it to glibc every time
+	r := fakecdn.Sample(testVersion)
+	r.Packages = append(r.Packages, fakecdn.Package{Name: "ncurses-compat",
+		Version: "6.1", Release: "28", Source: "ncurses",
+		Summary: "Compatibility links", License: "MIT",
+		Provides: []string{"libc6"},
+		Files:    map[string]string{"usr/lib64/libncurses.so.5": "ncurses"}})
+	cdn, cleanup := startCDN(t, r)
+	defer cleanup()
+
+	version := fmt.Sprint(testVersion)
+	run(t, "dissector", "-clear_version", version, "-repo_url", cdn.URL,
+		"-image", "kvm", "-export", "first.tar")
+	run(t, "dissector", "-clear_version", version, "-repo_url", cdn.URL,
+		"-image", "kvm", "-export", "second.tar")
+
+	first, err := ioutil.ReadFile("first.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ioutil.ReadFile("second.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Fatal("Exporting the same sources twice gave different archives")
+	}
+
+	if _, err := os.Stat(version + "/source/bash"); !os.IsNotExist(err) {
+		t.Fatal("Sources were extracted in export mode")
+	}
+
+	prefix := fmt.Sprintf("clear-linux-%d-source/", testVersion)
+	var names []string
+	contents := make(map[string]string)
+	tr := tar.NewReader(bytes.NewReader(first))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.ModTime.Unix() != 0 || header.Uid != 0 {
+			t.Fatalf("%s is not reproducible: %v", header.Name, header)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, strings.TrimPrefix(header.Name, prefix))
+		contents[strings.TrimPrefix(header.Name, prefix)] = string(content)
+	}
+
+	expected := []string{"", "INDEX", "README", "SRPMS/",
+		"SRPMS/bash-4.4-50.src.rpm", "SRPMS/glibc-2.27-187.src.rpm",
+		"SRPMS/linux-kvm-4.19.8-295.src.rpm", "SRPMS/ncurses-6.1-28.src.rpm",
+		"SRPMS/zlib-1.2.11-30.src.rpm"}
+	if strings.Join(names, " ") != strings.Join(expected, " ") {
+		t.Fatalf("Unexpected archive entries %v", names)
+	}
+
+	sum, err := downloader.ChecksumFile(version + "/srpms/glibc-2.27-187.src.rpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(contents["INDEX"], "libc6\tglibc-2.27-187.src.rpm\t"+sum+"\n") {
+		t.Fatalf("Unexpected index: %s", contents["INDEX"])
+	}
+	for _, expected := range []string{
+		"Corresponding source for Clear Linux OS, version 30000",
+		"Image: kvm", "three years",
+		"zlib-1.2.11-30.src.rpm        Zlib"} {
+		if !strings.Contains(contents["README"], expected) {
+			t.Fatalf("Expected %q in README: %s", expected, contents["README"])
+		}
+	}
+}
+
+func TestMix(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	// A mix adding its own package and rebuilding zlib on top of Clear
+	mix, err := fakecdn.New(&fakecdn.Release{
+		Version: 10,
+		Format:  1,
+		Sources: []fakecdn.Source{
+			{Name: "hello", Version: "1.0", Release: "1", License: "MIT",
+				Files: map[string]string{"hello.spec": "Name: hello\n"}},
+			{Name: "zlib", Version: "1.2.12", Release: "1", License: "Zlib",
+				Files: map[string]string{"zlib.spec": "Name: zlib\n"}},
+		},
+		Packages: []fakecdn.Package{
+			{Name: "hello", Version: "1.0", Release: "1", Source: "hello",
+				Summary: "Greeter", License: "MIT", Requires: []string{"libc6"},
+				Files: map[string]string{"usr/bin/hello": "hello"}},
+			{Name: "zlib-lib", Version: "1.2.12", Release: "1", Source: "zlib",
+				Summary: "Compression library", License: "Zlib",
+				Files: map[string]string{"usr/lib64/libz.so.1": "zlib"}},
+		},
+		Bundles: []fakecdn.Bundle{
+			{Name: "os-core",
+				Packages: []string{"bash", "glibc-bin", "libc6", "ncurses-lib"}},
+			{Name: "hello-app", Includes: []string{"os-core"},
+				Packages: []string{"hello", "zlib-lib"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mix.Close()
+
+	config := fmt.Sprintf(`{
+    "url": "%s",
+    "repos": [
+        {"name": "local"},
+        {"name": "clear", "url": "%s", "version": %d}
+    ]
+}`, mix.URL, cdn.URL, testVersion)
+	err = ioutil.WriteFile("mix.json", []byte(config), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(filepath.Join(binDir, "dissector"),
+		"-clear_version", "latest", "-mix", "mix.json", "-plan",
+		"hello-app", "os-core").Output()
+	if err != nil {
+		t.Fatalf("dissector -plan failed: %s\n%s", err, out)
+	}
+	for _, expected := range []string{
+		"download hello-1.0-1.src.rpm from local",
+		"download zlib-1.2.12-1.src.rpm from local",
+		"download bash-4.4-50.src.rpm from clear",
+		"Source rpms: 5, 5 to download",
+	} {
+		if !strings.Contains(string(out), expected) {
+			t.Fatalf("Expected %q in the plan: %s", expected, out)
+		}
+	}
+
+	run(t, "dissector", "-clear_version", "10", "-mix", "mix.json",
+		"hello-app", "os-core")
+	for dir, repo := range map[string]string{
+		"hello": "local", "zlib": "local", "bash": "clear", "glibc": "clear",
+		"ncurses": "clear",
+	} {
+		content, err := ioutil.ReadFile("10/source/" + dir + ".repo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(string(content)) != repo {
+			t.Fatalf("Expected %s to come from %s, not %s", dir, repo, content)
+		}
+		if _, err := os.Stat("10/source/" + dir + "/.repo"); !os.IsNotExist(err) {
+			t.Fatalf("The origin of %s was written into its tree", dir)
+		}
+	}
+	if _, err := os.Stat("10/srpms/zlib-1.2.11-30.src.rpm"); !os.IsNotExist(err) {
+		t.Fatal("The zlib of the mix should hide the one of Clear")
+	}
+
+	run(t, "dissector", "-clear_version", "10", "-mix", "mix.json",
+		"-export", "mix.tar", "hello-app")
+	contents := readArchive(t, "mix.tar")
+	index := contents["mix-10-source/INDEX"]
+	if !strings.Contains(index, "\tzlib-1.2.12-1.src.rpm\t") ||
+		!strings.Contains(index, "\tlocal\n") {
+		t.Fatalf("Unexpected index: %s", index)
+	}
+	if !strings.Contains(contents["mix-10-source/README"],
+		"Corresponding source for the mix of the clear and local repos, version 10") {
+		t.Fatalf("The README does not name the mix: %v", contents)
+	}
+
+	// A named mix labels the archive with its name
+	err = ioutil.WriteFile("mix.json", []byte(strings.Replace(config, "{",
+		`{"name": "Example OS",`, 1)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, "dissector", "-clear_version", "10", "-mix", "mix.json",
+		"-export", "named.tar", "hello-app")
+	contents = readArchive(t, "named.tar")
+	if !strings.Contains(contents["example-os-10-source/README"],
+		"Corresponding source for Example OS, version 10") {
+		t.Fatalf("The README does not name the mix: %v", contents)
+	}
+}
+
+// readArchive returns the content of every entry of a tar archive
+func readArchive(t *testing.T, path string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	contents := make(map[string]string)
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[header.Name] = string(content)
+	}
+	return contents
+}
+
+func TestChangelog(t *testing.T) {
+	updated := fakecdn.Sample(testVersion + 10)
+	for i, s := range updated.Sources {
+		if s.Name != "zlib" {
+			continue
+		}
+		updated.Sources[i].Release = "31"
+		updated.Sources[i].Files["CVE-2018-25032.patch"] = "fix deflate\n"
+		updated.Sources[i].Changelog = append([]fakecdn.ChangelogEntry{{
+			Author: "Clear Linux <dev@clearlinux.org>",
+			Date:   time.Date(2018, 9, 1, 12, 0, 0, 0, time.UTC),
+			Text:   "- Fix memory corruption in deflate",
+		}}, s.Changelog...)
+	}
+	for i, p := range updated.Packages {
+		if p.Source == "zlib" {
+			updated.Packages[i].Release = "31"
+		}
+	}
+	cdn, cleanup := startCDN(t, fakecdn.Sample(testVersion), updated)
+	defer cleanup()
+
+	// Progress goes to stderr so the report can be used as it is
+	out, err := exec.Command(filepath.Join(binDir, "changelog"), "-from",
+		fmt.Sprint(testVersion), "-url", cdn.URL, "-json", "zlib-lib",
+		"libc6", "glibc-bin").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var report struct {
+		From     int
+		To       int
+		Packages []repolib.SourceChanges
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if report.From != testVersion || report.To != testVersion+10 {
+		t.Fatalf("Unexpected versions in report: %s", out)
+	}
+	if len(report.Packages) != 2 {
+		t.Fatalf("Expected glibc and zlib in the report: %s", out)
+	}
+
+	glibc, zlib := report.Packages[0], report.Packages[1]
+	if glibc.Name != "glibc" || len(glibc.Changelog) != 0 || len(glibc.CVEs) != 0 ||
+		fmt.Sprint(glibc.Packages) != "[glibc-bin libc6]" {
+		t.Fatalf("Unexpected changes for glibc: %v", glibc)
+	}
+	if zlib.From != "zlib-1.2.11-30" || zlib.To != "zlib-1.2.11-31" ||
+		len(zlib.Changelog) != 1 ||
+		fmt.Sprint(zlib.CVEs) != "[CVE-2018-25032]" {
+		t.Fatalf("Unexpected changes for zlib: %v", zlib)
+	}
+
+	text := run(t, "changelog", "-from", fmt.Sprint(testVersion), "-url",
+		cdn.URL, "-bundles", "os-core-update")
+	for _, expected := range []string{
+		"zlib: zlib-1.2.11-30 -> zlib-1.2.11-31",
+		"CVEs: CVE-2018-25032",
+		"Sat Sep 01 2018 Clear Linux <dev@clearlinux.org>",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Fatalf("Expected %q in changelog: %s", expected, text)
+		}
+	}
+	if strings.Contains(text, "glibc") {
+		t.Fatalf("Unchanged glibc listed in changelog: %s", text)
+	}
+}
+
+func TestPkgInfo(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	// The JSON output stays clean while the cache is filled
+	version := fmt.Sprint(testVersion)
+	cmd := exec.Command(filepath.Join(binDir, "pkginfo"), "-clear_version",
+		version, "-repo_url", cdn.URL, "-json", "zlib-lib", "nosuchpackage")
+	json_out, err := cmd.Output()
+	if err == nil {
+		t.Fatal("Unknown package was not reported")
+	}
+	var packages []repolib.PackageInfo
+	if err = json.Unmarshal(json_out, &packages); err != nil {
+		t.Fatalf("%s: %s", err, json_out)
+	}
+	if len(packages) != 1 || packages[0].SourceRpm != "zlib-1.2.11-30.src.rpm" ||
+		packages[0].InstalledSize != 4 ||
+		fmt.Sprint(packages[0].Provides) != "[libz.so.1()(64bit) zlib-lib]" {
+		t.Fatalf("Unexpected package info: %s", json_out)
+	}
+
+	out := run(t, "pkginfo", "-clear_version", version, "-repo_url", cdn.URL,
+		"bash")
+	for _, expected := range []string{
+		"Name         : bash",
+		"Version      : 4.4",
+		"Source       : bash-4.4-50.src.rpm",
+		"License      : GPL-3.0",
+		"Requires     : libc.so.6()(64bit)\n               libncursesw.so.6()(64bit)",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("Expected %q in package info: %s", expected, out)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	version := fmt.Sprint(testVersion)
+	cmd := exec.Command(filepath.Join(binDir, "search"), "-clear_version",
+		version, "-repo_url", cdn.URL, "LIBRARY")
+	out_bytes, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out_bytes)
+	}
+	out := string(out_bytes)
+	expected := "[glibc-bin-2.27-187 : GNU C library utilities " +
+		"libc6-2.27-187 : GNU C library " +
+		"ncurses-lib-6.1-28 : Terminal handling library " +
+		"zlib-lib-1.2.11-30 : Compression library]"
+	if fmt.Sprint(sortedLines(out)) != expected {
+		t.Fatalf("Unexpected search results: %q", out)
+	}
+
+	cmd = exec.Command(filepath.Join(binDir, "search"), "-clear_version",
+		version, "-repo_url", cdn.URL, "-json", "-regex", "-fields",
+		"name,provides", `^lib.*\.so\.6`, "curses")
+	json_out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %s", err, json_out)
+	}
+	var results []repolib.SearchResult
+	if err = json.Unmarshal(json_out, &results); err != nil {
+		t.Fatalf("%s: %s", err, json_out)
+	}
+	if len(results) != 1 || results[0].Name != "ncurses-lib" ||
+		fmt.Sprint(results[0].Matches) != "[provides name]" {
+		t.Fatalf("Unexpected search results: %s", json_out)
+	}
+
+	cmd = exec.Command(filepath.Join(binDir, "search"), "-clear_version",
+		version, "-repo_url", cdn.URL, "-case", "LIBRARY")
+	if out, err := cmd.Output(); err == nil || len(out) != 0 {
+		t.Fatalf("Case sensitive search matched: %s", out)
+	}
+}
+
+func TestWhatProvides(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	version := fmt.Sprint(testVersion)
+	cmd := exec.Command(filepath.Join(binDir, "whatprovides"),
+		"-clear_version", version, "-repo_url", cdn.URL,
+		"libc.so.6()(64bit)", "/usr/bin/bash")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if fmt.Sprint(sortedLines(string(out))) != "[bash libc6]" {
+		t.Fatalf("Unexpected providers: %q", out)
+	}
+
+	// Only listed in filelists, not in the primary database
+	cmd = exec.Command(filepath.Join(binDir, "whatprovides"),
+		"-clear_version", version, "-repo_url", cdn.URL, "-json",
+		"/usr/lib64/libz.so.1", "libnothing.so")
+	out, err = cmd.Output()
+	if err == nil {
+		t.Fatal("Missing capability was not reported")
+	}
+	var providers map[string][]string
+	if err = json.Unmarshal(out, &providers); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if fmt.Sprint(providers) != "map[/usr/lib64/libz.so.1:[zlib-lib]]" {
+		t.Fatalf("Unexpected providers: %s", out)
+	}
+}
+
+func TestWhatRequires(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	version := fmt.Sprint(testVersion)
+	cmd := exec.Command(filepath.Join(binDir, "whatrequires"),
+		"-clear_version", version, "-repo_url", cdn.URL, "libc6")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if fmt.Sprint(sortedLines(string(out))) !=
+		"[bash glibc-bin ncurses-lib zlib-lib]" {
+		t.Fatalf("Unexpected packages requiring libc6: %q", out)
+	}
+
+	cmd = exec.Command(filepath.Join(binDir, "whatrequires"),
+		"-clear_version", version, "-repo_url", cdn.URL, "ncurses-lib")
+	out, err = cmd.Output()
+	if err != nil || strings.TrimSpace(string(out)) != "bash" {
+		t.Fatalf("Unexpected packages requiring ncurses-lib: %q", out)
+	}
+
+	// glibc-bin requires /usr/bin/bash from bash
+	cmd = exec.Command(filepath.Join(binDir, "whatrequires"),
+		"-clear_version", version, "-repo_url", cdn.URL, "-recursive",
+		"-json", "ncurses-lib")
+	out, err = cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	var requirers map[string][]string
+	if err = json.Unmarshal(out, &requirers); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	expected := "map[bash:[libncursesw.so.6()(64bit)] glibc-bin:[/usr/bin/bash]]"
+	if fmt.Sprint(requirers) != expected {
+		t.Fatalf("Unexpected reverse closure: %s", out)
+	}
+
+	cmd = exec.Command(filepath.Join(binDir, "whatrequires"),
+		"-clear_version", version, "-repo_url", cdn.URL, "ncurses")
+	out, err = cmd.Output()
+	if err == nil || !strings.Contains(string(out), "Did you mean ncurses-lib?") {
+		t.Fatalf("Expected a suggestion for an unknown package: %s", out)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	version := fmt.Sprint(testVersion)
+	cmd := exec.Command(filepath.Join(binDir, "explain"), "-clear_version",
+		version, "-repo_url", cdn.URL, "ncurses-lib", "os-core-update")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	expected := "os-core-update -> os-core (includes)\n" +
+		"os-core -> ncurses-lib (contains)\n"
+	if string(out) != expected {
+		t.Fatalf("Unexpected explanation: %q", out)
+	}
+
+	cmd = exec.Command(filepath.Join(binDir, "explain"), "-clear_version",
+		version, "-repo_url", cdn.URL, "-all", "-limit", "3", "libc6",
+		"os-core-update")
+	out, err = cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	expected = "os-core-update -> os-core (includes)\n" +
+		"os-core -> libc6 (contains)\n\n" +
+		"os-core-update -> zlib-lib (contains)\n" +
+		"zlib-lib -> libc6 (requires libc6)\n\n" +
+		"os-core-update -> os-core (includes)\n" +
+		"os-core -> bash (contains)\n" +
+		"bash -> libc6 (requires libc.so.6()(64bit))\n"
+	if string(out) != expected {
+		t.Fatalf("Unexpected explanations: %q", out)
+	}
+
+	cmd = exec.Command(filepath.Join(binDir, "explain"), "-clear_version",
+		version, "-repo_url", cdn.URL, "-all", "-limit", "0", "libc6",
+		"os-core-update")
+	out, err = cmd.Output()
+	if err == nil || !strings.Contains(string(out), "-limit must be at least 1") {
+		t.Fatalf("An unlimited number of paths was accepted: %s", out)
+	}
+
+	cmd = exec.Command(filepath.Join(binDir, "explain"), "-clear_version",
+		version, "-repo_url", cdn.URL, "-image", "kvm", "linux-kvm")
+	out, err = cmd.Output()
+	if err != nil || string(out) != "kernel-kvm -> linux-kvm (contains)\n" {
+		t.Fatalf("Unexpected explanation for the kvm image: %q", out)
+	}
+
+	cmd = exec.Command(filepath.Join(binDir, "explain"), "-clear_version",
+		version, "-repo_url", cdn.URL, "zlib-lib", "os-core")
+	out, err = cmd.Output()
+	if err == nil || !strings.Contains(string(out), "zlib-lib is not part") {
+		t.Fatalf("Package outside the bundles was explained: %s", out)
+	}
+}
+
+func TestDepGraph(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	version := fmt.Sprint(testVersion)
+	depgraph := func(args ...string) string {
+		cmd := exec.Command(filepath.Join(binDir, "depgraph"),
+			append([]string{"-clear_version", version, "-repo_url", cdn.URL},
+				args...)...)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("depgraph %s failed: %s\n%s", strings.Join(args, " "),
+				err, out)
+		}
+		return string(out)
+	}
+
+	out := depgraph("-types", "bundle,srpm", "-format", "json",
+		"os-core-update")
+	var g struct {
+		Nodes []struct {
+			ID string `json:"id"`
+		} `json:"nodes"`
+		Edges []struct {
+			From   string   `json:"from"`
+			To     string   `json:"to"`
+			Labels []string `json:"labels"`
+		} `json:"edges"`
+	}
+	if err := json.Unmarshal([]byte(out), &g); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	var nodes, edges []string
+	for _, n := range g.Nodes {
+		nodes = append(nodes, n.ID)
+	}
+	for _, e := range g.Edges {
+		edges = append(edges, fmt.Sprintf("%s>%s%v", e.From, e.To, e.Labels))
+	}
+	if fmt.Sprint(nodes) != "[bundle:os-core bundle:os-core-update "+
+		"srpm:bash srpm:glibc srpm:ncurses srpm:zlib]" {
+		t.Fatalf("Unexpected nodes: %s", out)
+	}
+	expected := "bundle:os-core-update>srpm:zlib[via zlib-lib]"
+	if !strings.Contains(fmt.Sprint(edges), expected) {
+		t.Fatalf("Expected edge %s: %v", expected, edges)
+	}
+
+	out = depgraph("-depth", "2", "os-core-update")
+	for _, line := range []string{
+		"digraph dependencies {",
+		`"bundle:os-core" [label="os-core", shape=box];`,
+		`"package:glibc-bin" -> "package:bash" [label="/usr/bin/bash"];`,
+		`"package:zlib-lib" -> "srpm:zlib" [label="built from"];`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("Expected %s in DOT output: %s", line, out)
+		}
+	}
+	if strings.Contains(out, "srpm:bash") {
+		t.Fatalf("Depth limit ignored: %s", out)
+	}
+
+	out = depgraph("-format", "graphml", "-types", "bundle", "-image", "kvm")
+	if !strings.Contains(out, `<node id="bundle:kernel-kvm">`) ||
+		!strings.Contains(out,
+			`<edge source="bundle:os-core-update" target="bundle:os-core">`) ||
+		strings.Contains(out, "package:") {
+		t.Fatalf("Unexpected GraphML output: %s", out)
+	}
+}
+
+func TestSize(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	version := fmt.Sprint(testVersion)
+	cmd := exec.Command(filepath.Join(binDir, "size"), "-clear_version",
+		version, "-repo_url", cdn.URL, "-json", "os-core-update")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	var report repolib.SizeReport
+	if err = json.Unmarshal(out, &report); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if len(report.Bundles) != 2 || report.Bundles[1].Name != "os-core-update" ||
+		fmt.Sprint(report.Bundles[1].Packages) != "[libc6 zlib-lib]" ||
+		report.Bundles[1].InstalledSize != 8 ||
+		report.Bundles[1].ContentSize != 4 ||
+		fmt.Sprint(report.Bundles[1].Shared) != "[libc6]" {
+		t.Fatalf("Unexpected bundle sizes: %s", out)
+	}
+	// Shared packages are only counted once in the total
+	if report.Packages != 5 || report.InstalledSize != 22 ||
+		report.ContentSize != 22 || len(report.Shared) != 1 ||
+		fmt.Sprint(report.Shared[0].Bundles) != "[os-core os-core-update]" {
+		t.Fatalf("Unexpected total: %s", out)
+	}
+
+	cmd = exec.Command(filepath.Join(binDir, "size"), "-clear_version",
+		version, "-repo_url", cdn.URL, "-image", "kvm", "-manifests=false")
+	out, err = cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	for _, expected := range []string{
+		"kernel-kvm      1         0",
+		"Total           6         1",
+		"libc6 (4 B installed): os-core, os-core-update",
+	} {
+		if !strings.Contains(string(out), expected) {
+			t.Fatalf("Expected %q in the sizes: %s", expected, out)
+		}
+	}
+	if !strings.HasSuffix(strings.Split(string(out), "\n")[1], "-") {
+		t.Fatalf("Content size reported without manifests: %s", out)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/graph"
+	"strings"
+	"testing"
+)
+
+func pathNames(path []graph.Edge) string {
+	var names []string
+	for i, e := range path {
+		if i == 0 {
+			names = append(names, e.From.Name)
+		}
+		names = append(names, e.To.Name)
+	}
+	return fmt.Sprint(names)
+}
+
+func TestGraphPaths(t *testing.T) {
+	b := func(name string) graph.Node {
+		return graph.Node{Kind: graph.Bundle, Name: name}
+	}
+	p := func(name string) graph.Node {
+		return graph.Node{Kind: graph.Package, Name: name}
+	}
+
+	g := graph.New()
+	g.AddEdge(b("top"), b("base"), "includes")
+	g.AddEdge(b("top"), p("app"), "contains")
+	g.AddEdge(b("base"), p("lib"), "contains")
+	g.AddEdge(p("app"), p("helper"), "libhelper.so")
+	g.AddEdge(p("app"), p("helper"), "/usr/bin/helper")
+	g.AddEdge(p("helper"), p("lib"), "liblib.so")
+	g.AddEdge(p("lib"), p("helper"), "/usr/bin/helper")
+
+	path := g.ShortestPath([]graph.Node{b("top")}, p("lib"))
+	if pathNames(path) != "[top base lib]" {
+		t.Fatalf("Unexpected shortest path %s", pathNames(path))
+	}
+
+	edges := g.OutEdges(p("app"))
+	if len(edges) != 1 || edges[0].Label() != "/usr/bin/helper, libhelper.so" {
+		t.Fatalf("Unexpected edges %v", edges)
+	}
+
+	var all []string
+	for _, path := range g.AllPaths([]graph.Node{b("top")}, p("lib"), 10) {
+		all = append(all, pathNames(path))
+	}
+	if fmt.Sprint(all) != "[[top base lib] [top app helper lib]]" {
+		t.Fatalf("Unexpected paths %v", all)
+	}
+
+	if g.ShortestPath([]graph.Node{p("lib")}, b("top")) != nil {
+		t.Fatal("Found a path against the edges")
+	}
+	if len(g.AllPaths([]graph.Node{b("top")}, p("lib"), 1)) != 1 {
+		t.Fatal("Path limit ignored")
+	}
+}
+
+func TestGraphFilters(t *testing.T) {
+	b := graph.Node{Kind: graph.Bundle, Name: "base"}
+	app := graph.Node{Kind: graph.Package, Name: "app"}
+	lib := graph.Node{Kind: graph.Package, Name: "lib"}
+	src := graph.Node{Kind: graph.Srpm, Name: "lib-src"}
+
+	g := graph.New()
+	g.AddEdge(b, app, "contains")
+	g.AddEdge(app, lib, "lib.so")
+	g.AddEdge(lib, src, "built from")
+
+	within := g.Within([]graph.Node{b}, 1)
+	if fmt.Sprint(within.Nodes()) != "[{bundle base} {package app}]" {
+		t.Fatalf("Unexpected nodes within one edge %v", within.Nodes())
+	}
+
+	only := g.Only(graph.Bundle, graph.Srpm)
+	edges := only.Edges()
+	if len(edges) != 1 || edges[0].From != b || edges[0].To != src ||
+		edges[0].Label() != "via app" {
+		t.Fatalf("Unexpected shortened edges %v", edges)
+	}
+
+	var out bytes.Buffer
+	err := graph.Write(&out, only, "dot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "digraph dependencies {\n" +
+		"\t\"bundle:base\" [label=\"base\", shape=box];\n" +
+		"\t\"srpm:lib-src\" [label=\"lib-src\", shape=note];\n" +
+		"\t\"bundle:base\" -> \"srpm:lib-src\" [label=\"via app\"];\n}\n"
+	if out.String() != expected {
+		t.Fatalf("Unexpected DOT output %q", out.String())
+	}
+
+	err = graph.Write(&out, g, "svg")
+	if err == nil || !strings.Contains(err.Error(), "Unknown graph format") {
+		t.Fatalf("Unexpected error for an unknown format: %v", err)
+	}
+}
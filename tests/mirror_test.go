@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/fakecdn"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logBuffer collects the request log of a handler, which is written from
+// the goroutines of the test server after the response was sent
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *logBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
+
+// waitFor gives the handler a moment to log the request it just answered
+func (b *logBuffer) waitFor(line string) bool {
+	for i := 0; i < 50; i++ {
+		if strings.Contains(b.String(), line) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// getBody fetches url, which has to be found
+func getBody(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected %s to be found, got %s", url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMirror(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	var requests logBuffer
+	mirror := downloader.NewMirror(cdn.URL, "cache")
+	mirror.Verify = repolib.MirrorVerifier("cache", cdn.URL, nil)
+	mirror.Local = repolib.VersionCachePaths
+	mirror.Log = log.New(&requests, "", 0)
+	ts := httptest.NewServer(mirror)
+	defer ts.Close()
+
+	version := fmt.Sprint(testVersion)
+	run(t, "dissector", "-clear_version", "latest", "-repo_url", ts.URL,
+		"os-core")
+	if _, err := os.Stat(version + "/source/bash.complete"); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{
+		"releases/30000/clear/source/SRPMS/bash-4.4-50.src.rpm",
+		"releases/30000/clear/x86_64/os/repodata/repomd.xml",
+		"update/30000/pack-os-core-update-index-from-0.tar",
+	} {
+		if _, err := os.Stat("cache/" + file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !requests.waitFor("GET /current/latest pass 200") {
+		t.Fatalf("The latest version should be passed through: %s",
+			requests.String())
+	}
+
+	// A second client is served from the cache
+	requests.Reset()
+	err := os.RemoveAll(version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, "downloadpackages", "-clear_version", version, "-url", ts.URL,
+		"bash")
+	if !requests.waitFor(
+		"GET /releases/30000/clear/source/SRPMS/bash-4.4-50.src.rpm hit 200") {
+		t.Fatalf("Expected a cache hit: %s", requests.String())
+	}
+
+	// Files the tools already downloaded are served from the version cache
+	bash := "releases/30000/clear/source/SRPMS/bash-4.4-50.src.rpm"
+	err = os.Remove("cache/" + bash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := ioutil.ReadFile(version + "/source/bash-4.4-50.src.rpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests.Reset()
+	if served := getBody(t, ts.URL+"/"+bash); served != string(local) {
+		t.Fatal("The srpm of the version cache was not served")
+	}
+	if !requests.waitFor("GET /" + bash + " hit 200") {
+		t.Fatalf("Expected a cache hit: %s", requests.String())
+	}
+	if _, err := os.Stat("cache/" + bash); !os.IsNotExist(err) {
+		t.Fatal("A file of the version cache was mirrored again")
+	}
+
+	// unless they do not match the repo metadata
+	err = ioutil.WriteFile(version+"/source/bash-4.4-50.src.rpm",
+		[]byte("tampered"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests.Reset()
+	if served := getBody(t, ts.URL+"/"+bash); served != string(local) {
+		t.Fatal("A damaged srpm of the version cache was served")
+	}
+	if !requests.waitFor("GET /" + bash + " miss 200") {
+		t.Fatalf("Expected a cache miss: %s", requests.String())
+	}
+
+	// Files not matching the repo metadata are refused and not cached
+	srpm := "releases/30000/clear/source/SRPMS/zlib-1.2.11-30.src.rpm"
+	err = ioutil.WriteFile(filepath.Join(cdn.Root, srpm), []byte("tampered"),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(filepath.Join(binDir, "downloadpackages"),
+		"-clear_version", version, "-url", ts.URL, "zlib-lib").CombinedOutput()
+	if err == nil {
+		t.Fatalf("Downloading a tampered srpm did not fail: %s", out)
+	}
+	if _, err := os.Stat("cache/" + srpm); !os.IsNotExist(err) {
+		t.Fatal("The tampered srpm was cached")
+	}
+
+	// Partial downloads and locks in the cache are never served
+	for _, suffix := range []string{".tmp", ".lock"} {
+		err = ioutil.WriteFile("cache/"+bash+suffix, []byte("partial"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for path, status := range map[string]int{
+		"/" + srpm:                  http.StatusBadGateway,
+		"/" + bash + ".tmp":         http.StatusNotFound,
+		"/" + bash + ".lock":        http.StatusNotFound,
+		"/releases/30000/missing":   http.StatusNotFound,
+		"/releases/30000/.repos/x":  http.StatusNotFound,
+		"/etc/passwd":               http.StatusNotFound,
+		"/releases/../../etc/hosts": http.StatusNotFound,
+	} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Fatalf("Expected status %d for %s, got %s", status, path,
+				resp.Status)
+		}
+	}
+	if _, err := os.Stat("cache/releases/30000/missing"); !os.IsNotExist(err) {
+		t.Fatal("A missing file was cached")
+	}
+}
+
+func TestMirrorUpdate(t *testing.T) {
+	signer, err := fakecdn.NewCertSigner("Clear Linux swupd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := fakecdn.NewCertSigner("Someone else")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := fakecdn.Sample(testVersion)
+	r.SwupdSigner = signer
+	cdn, cleanup := startCDN(t, r)
+	defer cleanup()
+
+	for path, s := range map[string]*fakecdn.CertSigner{
+		"clear.pem": signer,
+		"other.pem": other,
+	} {
+		if err = s.WriteCertificate(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trust, err := repolib.LoadTrust("", "clear.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	untrusted, err := repolib.LoadTrust("", "other.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mirror := downloader.NewMirror(cdn.URL, "cache")
+	mirror.Verify = repolib.MirrorVerifier("cache", cdn.URL, trust)
+	mirror.Log = log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(mirror)
+	defer ts.Close()
+
+	update := "update/30000/"
+	for _, file := range []string{"Manifest.MoM", "Manifest.os-core"} {
+		getBody(t, ts.URL+"/"+update+file)
+		if _, err := os.Stat("cache/" + update + file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Manifests not matching Manifest.MoM are refused and not cached
+	err = ioutil.WriteFile(filepath.Join(cdn.Root, update+"Manifest.os-core-update"),
+		[]byte("tampered"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(ts.URL + "/" + update + "Manifest.os-core-update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("A tampered manifest was served: %s", resp.Status)
+	}
+	if _, err := os.Stat("cache/" + update + "Manifest.os-core-update"); !os.IsNotExist(err) {
+		t.Fatal("The tampered manifest was cached")
+	}
+
+	// and so is Manifest.MoM without a trusted signature
+	mirror = downloader.NewMirror(cdn.URL, "untrusted")
+	mirror.Verify = repolib.MirrorVerifier("untrusted", cdn.URL, untrusted)
+	mirror.Log = log.New(ioutil.Discard, "", 0)
+	untrustedServer := httptest.NewServer(mirror)
+	defer untrustedServer.Close()
+	resp, err = http.Get(untrustedServer.URL + "/" + update + "Manifest.MoM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("An untrusted Manifest.MoM was served: %s", resp.Status)
+	}
+}
@@ -1,52 +1,100 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
 	"github.com/intel/clear-linux-dissector/internal/downloader"
+	"github.com/intel/clear-linux-dissector/internal/fakecdn"
 	"github.com/intel/clear-linux-dissector/internal/repolib"
-	"net/http"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/rustylynch/go-rpmutils"
 )
 
-func getCurrentVersion() (version int, err error) {
-	resp, err := http.Get("https://cdn.download.clearlinux.org/current/latest")
+const testVersion = 30000
+
+// startCDN serves a sample release and switches into an empty working
+// directory, since the tools keep their cache relative to it
+func startCDN(t *testing.T, releases ...*fakecdn.Release) (*fakecdn.CDN, func()) {
+	if len(releases) == 0 {
+		releases = append(releases, fakecdn.Sample(testVersion))
+	}
+
+	cdn, err := fakecdn.New(releases...)
 	if err != nil {
-		return 0, err
+		t.Fatal(err)
 	}
 
-	_, err = fmt.Fscanf(resp.Body, "%d", &version)
+	dir, err := ioutil.TempDir("", "dissector-test")
 	if err != nil {
-		return 0, err
+		cdn.Close()
+		t.Fatal(err)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	return cdn, func() {
+		os.Chdir(cwd)
+		os.RemoveAll(dir)
+		cdn.Close()
 	}
-	return version, nil
 }
 
 func TestRepoLib(t *testing.T) {
-	version, err := getCurrentVersion()
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	version, err := common.GetLatestVersion(common.DefaultLayout(cdn.URL))
 	if err != nil {
 		t.Fatal(err)
 	}
+	if version != testVersion {
+		t.Fatalf("Expected latest version %d, got %d", testVersion, version)
+	}
 
-	err = repolib.DownloadRepo(version,
-		"https://cdn.download.clearlinux.org")
+	err = repolib.DownloadRepo(version, common.DefaultLayout(cdn.URL), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = repolib.GetBundle(version, "os-core")
+	b, err := repolib.GetBundle(version, "os-core", common.DefaultLayout(cdn.URL), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if _, ok := b["AllPackages"].(map[string]interface{})["libc6"]; !ok {
+		t.Fatal("os-core bundle is missing libc6")
+	}
 
-	_, err = repolib.GetPkgMap(version)
+	pmap, err := repolib.GetPkgMap(version)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if pmap["libc6"] != "glibc-2.27-187.src.rpm" {
+		t.Fatalf("Unexpected SRPM for libc6: %s", pmap["libc6"])
+	}
 
-	_, err = repolib.GetSrpmHashMap(version)
+	hashmap, err := repolib.GetSrpmHashMap(version)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(hashmap) != 5 {
+		t.Fatalf("Expected 5 SRPM hashes, got %d", len(hashmap))
+	}
 
 	r := make(map[string]bool)
 	r["libc6"] = true
@@ -54,18 +102,789 @@ func TestRepoLib(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	srpm := "glibc-2.27-187.src.rpm"
+	if len(pkgs) != 1 || fmt.Sprint(pkgs[srpm]) != "[libc6]" {
+		t.Fatalf("Expected only %s to satisfy libc6, got %v", srpm, pkgs)
+	}
+
+	url := fmt.Sprintf("%s/releases/%d/clear/source/SRPMS/%s",
+		cdn.URL, version, srpm)
+	target := fmt.Sprintf("%d/srpms/%s", version, srpm)
+	err = downloader.DownloadFile(target, url, hashmap[srpm], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := fmt.Sprintf("%d/source/%s", version, srpm)
+	err = repolib.ExtractRpm(target, dst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !repolib.IsExtracted(dst, hashmap[srpm]) {
+		t.Fatal("Extracted tree is missing its completion marker")
+	}
+
+	files, err := ioutil.ReadDir(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+	expected := fmt.Sprint([]string{"CVE-2018-11236.patch",
+		"glibc-2.27.tar.xz", "glibc.spec"})
+	if fmt.Sprint(names) != expected {
+		t.Fatalf("Unexpected extracted content %v", names)
+	}
+}
+
+func TestQueryReqs(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	err := repolib.DownloadRepo(testVersion, common.DefaultLayout(cdn.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Quotes must not break the query and large sets are split into
+	// several statements
+	r := map[string]bool{
+		"libc.so.6()(64bit)":        true,
+		"libncursesw.so.6()(64bit)": true,
+		"libc6":                     true,
+		"bad\"name'":                true,
+	}
+	for i := 0; i < 2000; i++ {
+		r[fmt.Sprintf("missing-%d", i)] = true
+	}
+
+	pkgs, err := repolib.QueryReqs(testVersion, r, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "map[libc6:[libc.so.6()(64bit) libc6] " +
+		"ncurses-lib:[libncursesw.so.6()(64bit)]]"
+	if fmt.Sprint(pkgs) != expected {
+		t.Fatalf("Unexpected providers %v", pkgs)
+	}
+
+	_, err = repolib.QueryReqs(testVersion, r, "name; DROP TABLE packages")
+	if err == nil {
+		t.Fatal("Arbitrary fields are accepted")
+	}
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	url := fmt.Sprintf("%s/releases/%d/clear/source/SRPMS/bash-4.4-50.src.rpm",
+		cdn.URL, testVersion)
+	err := downloader.DownloadFile("bash.src.rpm", url, "0000", "")
+	if err == nil {
+		t.Fatal("Download with a bad checksum succeeded")
+	}
+	if _, err := os.Stat("bash.src.rpm"); !os.IsNotExist(err) {
+		t.Fatal("Download with a bad checksum left a file behind")
+	}
+}
+
+func TestVerifyRpm(t *testing.T) {
+	signer, err := fakecdn.NewSigner("Clear Linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := fakecdn.NewSigner("Someone else")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := fakecdn.Sample(testVersion)
+	signed.Signer = signer
+	unsigned := fakecdn.Sample(testVersion + 10)
+	cdn, cleanup := startCDN(t, signed, unsigned)
+	defer cleanup()
+
+	err = fakecdn.WritePublicKey(signer, "clear.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fakecdn.WritePublicKey(other, "other.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := repolib.LoadKeyring("clear.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeyring, err := repolib.LoadKeyring("other.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []int{testVersion, testVersion + 10} {
+		url := fmt.Sprintf("%s/releases/%d/clear/source/SRPMS/zlib-1.2.11-30.src.rpm",
+			cdn.URL, version)
+		err = downloader.DownloadFile(fmt.Sprintf("zlib-%d.src.rpm", version),
+			url, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = repolib.VerifyRpm(fmt.Sprintf("zlib-%d.src.rpm", testVersion), keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repolib.VerifyRpm(fmt.Sprintf("zlib-%d.src.rpm", testVersion), otherKeyring)
+	if err == nil {
+		t.Fatal("Package signed by an unknown key passed verification")
+	}
+
+	err = repolib.VerifyRpm(fmt.Sprintf("zlib-%d.src.rpm", testVersion+10), keyring)
+	if err != repolib.ErrUnsigned {
+		t.Fatalf("Unsigned package not reported as such: %v", err)
+	}
+
+	// Signed packages must still extract normally
+	err = repolib.ExtractRpm(fmt.Sprintf("zlib-%d.src.rpm", testVersion),
+		"zlib", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTrustedDownloads(t *testing.T) {
+	signer, err := fakecdn.NewSigner("Clear Linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := fakecdn.NewSigner("Someone else")
+	if err != nil {
+		t.Fatal(err)
+	}
+	swupdSigner, err := fakecdn.NewCertSigner("Clear Linux swupd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSwupd, err := fakecdn.NewCertSigner("Someone else")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := fakecdn.Sample(testVersion)
+	r.Signer = signer
+	r.SwupdSigner = swupdSigner
+	cdn, cleanup := startCDN(t, r)
+	defer cleanup()
+
+	for path, write := range map[string]func(string) error{
+		"clear.asc": func(p string) error { return fakecdn.WritePublicKey(signer, p) },
+		"other.asc": func(p string) error { return fakecdn.WritePublicKey(other, p) },
+		"clear.pem": swupdSigner.WriteCertificate,
+		"other.pem": otherSwupd.WriteCertificate,
+	} {
+		if err = write(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	untrusted, err := repolib.LoadTrust("other.asc", "other.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repolib.DownloadRepo(testVersion, common.DefaultLayout(cdn.URL), untrusted)
+	if err == nil {
+		t.Fatal("Repo signed by an unknown key passed verification")
+	}
+	err = repolib.DownloadBundles(testVersion, common.DefaultLayout(cdn.URL), untrusted)
+	if err == nil {
+		t.Fatal("Bundles signed by an unknown certificate passed verification")
+	}
+
+	trust, err := repolib.LoadTrust("clear.asc", "clear.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(fmt.Sprint(testVersion))
+	err = repolib.DownloadRepo(testVersion, common.DefaultLayout(cdn.URL), trust)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repolib.GetBundle(testVersion, "os-core", common.DefaultLayout(cdn.URL), trust)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	url := fmt.Sprintf("https://cdn.download.clearlinux.org/" +
-		"releases/%d/clear/source/SRPMS/%s", version, pkgs[0])
-	target := fmt.Sprintf("%d/srpms/%s", version, pkgs[0])
-	err = downloader.DownloadFile(target, url, "", "")
+	// Manifest.MoM cached without verification is replaced by a trusted one
+	mom := fmt.Sprintf("%d/manifests/Manifest.MoM", testVersion)
+	err = os.MkdirAll(filepath.Dir(mom), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(mom, []byte("MANIFEST\t30\n"), 0644)
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, err = repolib.GetBundleManifest(testVersion, "os-core", common.DefaultLayout(cdn.URL), trust)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := ioutil.ReadFile(mom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := ioutil.ReadFile(filepath.Join(cdn.Root, "update",
+		fmt.Sprint(testVersion), "Manifest.MoM"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached, upstream) {
+		t.Fatal("The unverified Manifest.MoM was kept")
+	}
+	_, err = repolib.GetBundleManifest(testVersion, "os-core", common.DefaultLayout(cdn.URL), untrusted)
+	if err == nil {
+		t.Fatal("Manifest.MoM signed by an unknown certificate passed verification")
+	}
 
-	dst := fmt.Sprintf("%d/source/%s", version, pkgs[0])
-	err = repolib.ExtractRpm(target, dst)
+	// The cached copies are verified again on every use
+	err = repolib.DownloadRepo(testVersion, common.DefaultLayout(cdn.URL), trust)
 	if err != nil {
 		t.Fatal(err)
 	}
+	bundle := fmt.Sprintf("%d/bundles/os-core", testVersion)
+	err = ioutil.WriteFile(bundle, []byte(`{"Name": "os-core"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repolib.GetBundle(testVersion, "os-core", common.DefaultLayout(cdn.URL), trust)
+	if err == nil {
+		t.Fatal("Tampered bundle passed verification")
+	}
+	primary := fmt.Sprintf("%d/repodata/primary.sqlite", testVersion)
+	err = ioutil.WriteFile(primary, []byte("tampered"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repolib.DownloadRepo(testVersion, common.DefaultLayout(cdn.URL), trust)
+	if err == nil {
+		t.Fatal("Tampered repo metadata passed verification")
+	}
+}
+
+func TestLegacyRepoCache(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	// Caches of older versions kept the primary database only
+	layout := common.DefaultLayout(cdn.URL)
+	err := repolib.DownloadRepo(testVersion, layout, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repomd := fmt.Sprintf("%d/srpms/repodata/repomd.xml", testVersion)
+	if err = os.Remove(repomd); err != nil {
+		t.Fatal(err)
+	}
+
+	err = repolib.DownloadSourceDatabases(testVersion, layout, nil, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{repomd,
+		fmt.Sprintf("%d/srpms/repodata/other.sqlite", testVersion)} {
+		if _, err := os.Stat(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConcurrentDownloads(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	srpm := fmt.Sprintf("%d/srpms/zlib-1.2.11-30.src.rpm", testVersion)
+	url := fmt.Sprintf("%s/releases/%d/clear/source/SRPMS/zlib-1.2.11-30.src.rpm",
+		cdn.URL, testVersion)
+	target := fmt.Sprintf("%d/source/zlib", testVersion)
+
+	errs := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			err := repolib.DownloadRepo(testVersion, common.DefaultLayout(cdn.URL), nil)
+			if err == nil {
+				_, err = repolib.GetBundle(testVersion, "os-core", common.DefaultLayout(cdn.URL), nil)
+			}
+			if err == nil {
+				_, err = repolib.GetBundleManifest(testVersion, "os-core", common.DefaultLayout(cdn.URL), nil)
+			}
+			if err == nil {
+				hashmap, err := repolib.GetSrpmHashMap(testVersion)
+				if err != nil {
+					errs <- err
+					return
+				}
+				err = downloader.DownloadFile(srpm, url,
+					hashmap["zlib-1.2.11-30.src.rpm"], "")
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+			if err == nil {
+				err = repolib.ExtractRpm(srpm, target, false)
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !repolib.IsExtracted(target, "") {
+		t.Fatal("zlib was not extracted")
+	}
+	leftovers, err := repolib.CacheLeftovers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftovers) > 0 {
+		t.Fatalf("Concurrent downloads left %v behind", leftovers)
+	}
+	for _, lock := range []string{srpm + ".lock", target + ".lock",
+		fmt.Sprintf("%d/repodata/.lock", testVersion),
+		fmt.Sprintf("%d/bundles/.lock", testVersion)} {
+		if _, err := os.Stat(lock); !os.IsNotExist(err) {
+			t.Fatalf("Lock %s was not released", lock)
+		}
+	}
+}
+
+// holdLock runs in a copy of the test binary started by
+// TestCrossProcessLock. It takes the lock of a tree, reports that on stdout
+// and completes the tree itself once stdin is closed.
+func holdLock(target string, checksum string) {
+	lock, err := downloader.Lock(target)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("locked")
+	ioutil.ReadAll(os.Stdin)
+
+	err = os.MkdirAll(target, 0755)
+	if err == nil {
+		err = ioutil.WriteFile(target+"/extracted-by-helper", nil, 0644)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(target+".complete", []byte(checksum+"\n"), 0644)
+	}
+	downloader.Unlock(lock)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestCrossProcessLock(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	srpm := "zlib-1.2.11-30.src.rpm"
+	url := fmt.Sprintf("%s/releases/%d/clear/source/SRPMS/%s", cdn.URL,
+		testVersion, srpm)
+	err := downloader.DownloadFile(srpm, url, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := downloader.ChecksumFile(srpm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process holds the lock of the tree and completes it
+	target := "zlib"
+	helper := exec.Command(os.Args[0])
+	helper.Env = append(os.Environ(), "DISSECTOR_TEST_LOCK="+target,
+		"DISSECTOR_TEST_CHECKSUM="+checksum)
+	stdin, err := helper.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := helper.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = helper.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Process.Kill()
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || line != "locked\n" {
+		t.Fatalf("Helper process failed to lock %s: %q %v", target, line, err)
+	}
+
+	if _, err := downloader.TryLock(target); err != downloader.ErrLocked {
+		t.Fatalf("Expected the lock to be held by the helper, got %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- repolib.ExtractRpm(srpm, target, false)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Extraction did not wait for the other process: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	stdin.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := helper.Wait(); err != nil {
+		t.Fatalf("Helper process failed: %v", err)
+	}
+	if _, err := os.Stat(target + "/extracted-by-helper"); err != nil {
+		t.Fatal("The tree completed by the other process was extracted again")
+	}
+	if !repolib.IsExtracted(target, checksum) {
+		t.Fatal("zlib is not marked as extracted")
+	}
+
+	// A complete tree is kept unless forced
+	if err = repolib.ExtractRpm(srpm, target, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(target + "/extracted-by-helper"); err != nil {
+		t.Fatal("A complete tree was extracted again")
+	}
+	if err = repolib.ExtractRpm(srpm, target, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(target + "/extracted-by-helper"); !os.IsNotExist(err) {
+		t.Fatal("Forced extraction kept the old tree")
+	}
+}
+
+func TestParseRpmFilename(t *testing.T) {
+	for filename, expected := range map[string]string{
+		"bash-4.4-50.src.rpm":              "bash 4.4 50 src",
+		"perl-Test-Simple-1.302-7.src.rpm": "perl-Test-Simple 1.302 7 src",
+		"libc6-2.27-187.x86_64.rpm":        "libc6 2.27 187 x86_64",
+	} {
+		nevra, err := repolib.ParseRpmFilename(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := strings.Join([]string{nevra.Name, nevra.Version,
+			nevra.Release, nevra.Arch}, " ")
+		if actual != expected {
+			t.Fatalf("%s parsed as %q, expected %q", filename, actual, expected)
+		}
+	}
+
+	for _, filename := range []string{"bash.src.rpm", "bash-4.4.src.rpm",
+		"bash-4.4-50.tar.gz", "-4.4-50.src.rpm"} {
+		if _, err := repolib.ParseRpmFilename(filename); err == nil {
+			t.Fatalf("%s was accepted as an rpm file name", filename)
+		}
+	}
+}
+
+func TestSourceDir(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	err := repolib.DownloadRepo(testVersion, common.DefaultLayout(cdn.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nevras, err := repolib.GetSrpmNevraMap(testVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nevra, err := repolib.SrpmNevra(nevras, "linux-kvm-4.19.8-295.src.rpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nevra.Epoch != "0" {
+		t.Fatalf("Epoch not taken from the repo: %v", nevra)
+	}
+	for layout, expected := range map[string]string{
+		repolib.LayoutName: "linux-kvm",
+		repolib.LayoutNVR:  "linux-kvm-4.19.8-295",
+	} {
+		dir, err := repolib.SourceDir(nevra, layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dir != expected {
+			t.Fatalf("%s layout gave %s, expected %s", layout, dir, expected)
+		}
+	}
+	if _, err = repolib.SourceDir(nevra, "flat"); err == nil {
+		t.Fatal("Unknown layout was accepted")
+	}
+}
+
+func TestResolveVersion(t *testing.T) {
+	old := fakecdn.Sample(testVersion)
+	old.Format = 29
+	cdn, cleanup := startCDN(t, old, fakecdn.Sample(testVersion+10),
+		fakecdn.Sample(testVersion+20))
+	defer cleanup()
+
+	for spec, expected := range map[string]int{
+		"latest":   testVersion + 20,
+		"latest-0": testVersion + 20,
+		"latest-1": testVersion + 10,
+		"latest-2": testVersion,
+		"format29": testVersion,
+		"format30": testVersion + 20,
+		"30010":    30010,
+	} {
+		version, err := common.ResolveVersion(spec, common.DefaultLayout(cdn.URL))
+		if err != nil {
+			t.Fatalf("%s: %s", spec, err)
+		}
+		if version != expected {
+			t.Fatalf("%s resolved to %d, expected %d", spec, version, expected)
+		}
+	}
+
+	for _, spec := range []string{"latest-3", "format28", "newest", "-10"} {
+		if _, err := common.ResolveVersion(spec, common.DefaultLayout(cdn.URL)); err == nil {
+			t.Fatalf("%s was resolved", spec)
+		}
+	}
+}
+
+func TestFindCVEs(t *testing.T) {
+	cves := repolib.FindCVEs("- Fix cve-2018-11236 and CVE-2018-6485",
+		"CVE-2018-11236.patch", "no identifiers, CVE-18-1")
+	if fmt.Sprint(cves) != "[CVE-2018-11236 CVE-2018-6485]" {
+		t.Fatalf("Unexpected CVEs %v", cves)
+	}
+}
+
+func TestClosestNames(t *testing.T) {
+	candidates := []string{"bash", "bash-bin", "zlib", "zlib-lib", "glibc"}
+	for _, test := range []struct {
+		name     string
+		expected string
+	}{
+		{"bsah", "[bash]"},
+		{"zlib", "[zlib-lib]"},
+		{"Glibc", "[glibc]"},
+		{"bash-bni", "[bash-bin]"},
+		{"python3", "[]"},
+	} {
+		closest := repolib.ClosestNames(test.name, candidates, 3)
+		if fmt.Sprint(closest) != test.expected {
+			t.Errorf("Closest names to %s: %v, expected %s", test.name,
+				closest, test.expected)
+		}
+	}
+
+	if s := repolib.Suggest("python3", candidates); s != "" {
+		t.Fatalf("Unexpected suggestion %q", s)
+	}
+
+	srpms := map[string]string{"bash": "bash-4.4-50.src.rpm"}
+	for name, expected := range map[string]string{
+		"bsah":    "No bsah! Did you mean bash?",
+		"python3": "No python3!",
+	} {
+		msg := repolib.SuggestFrom("No "+name+"!", name, srpms)
+		if msg != expected {
+			t.Errorf("Expected %q, got %q", expected, msg)
+		}
+	}
+	msg := repolib.AddSuggestion("No package bsah", "bsah", []string{"bash"})
+	if msg != "No package bsah. Did you mean bash?" {
+		t.Errorf("Unexpected suggestion %q", msg)
+	}
+}
+
+func TestBundleManifest(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	m, err := repolib.GetBundleManifest(testVersion, "os-core", common.DefaultLayout(cdn.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Find("/usr/bin/bash"); !ok || m.Header["contentsize"] != "18" {
+		t.Fatalf("Unexpected manifest %v", m)
+	}
+
+	// A damaged copy in the cache is downloaded again
+	path := fmt.Sprintf("%d/manifests/Manifest.os-core.%d", testVersion,
+		testVersion)
+	err = ioutil.WriteFile(path, []byte("MANIFEST\t30\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = repolib.GetBundleManifest(testVersion, "os-core", common.DefaultLayout(cdn.URL), nil)
+	if err != nil || len(m.Entries) != 4 {
+		t.Fatalf("Damaged manifest was not replaced: %v %v", err, m)
+	}
+
+	_, err = repolib.GetBundleManifest(testVersion, "os-cor", common.DefaultLayout(cdn.URL), nil)
+	if err == nil || !strings.Contains(err.Error(), "Did you mean os-core") {
+		t.Fatalf("Unexpected error for an unknown bundle: %v", err)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	release := fakecdn.Sample(testVersion)
+	release.Arch = "aarch64"
+	cdn, cleanup := startCDN(t, release)
+	defer cleanup()
+
+	layout := common.DefaultLayout(cdn.URL + "/")
+	expected := cdn.URL + "/releases/30000/clear/x86_64/os"
+	if layout.BinaryRepoURL(testVersion) != expected {
+		t.Fatalf("Unexpected binary repo %s", layout.BinaryRepoURL(testVersion))
+	}
+	expected = cdn.URL + "/update/version/format30/latest"
+	if layout.FormatLatestURL(30) != expected {
+		t.Fatalf("Unexpected format URL %s", layout.FormatLatestURL(30))
+	}
+
+	if repolib.DownloadRepo(testVersion, layout, nil) == nil {
+		t.Fatal("Expected no x86_64 repo in an aarch64 tree")
+	}
+
+	layout, err := common.LoadLayout("", cdn.URL, "aarch64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repolib.DownloadRepo(testVersion, layout, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgs, err := repolib.QueryReqs(testVersion, map[string]bool{"bash": true},
+		"location_href")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(pkgs) != "map[Packages/bash-4.4-50.aarch64.rpm:[bash]]" {
+		t.Fatalf("Unexpected aarch64 packages: %v", pkgs)
+	}
+
+	err = ioutil.WriteFile("layout.json", []byte(`{"arch": "riscv64", `+
+		`"update": "{url}/mix/{version}/update", "images": ""}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = common.LoadLayout("layout.json", cdn.URL, ""); err == nil {
+		t.Fatal("Expected a layout without images to be rejected")
+	}
+
+	err = ioutil.WriteFile("layout.json", []byte(`{"arch": "riscv64", `+
+		`"update": "{url}/mix/{version}/update"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout, err = common.LoadLayout("layout.json", cdn.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.UpdateURL(10) != cdn.URL+"/mix/10/update" ||
+		layout.BinaryRepoURL(10) != cdn.URL+"/releases/10/clear/riscv64/os" {
+		t.Fatalf("Unexpected layout %+v", layout)
+	}
+	if layout.DebugRepoURL(10) != cdn.URL+"/releases/10/clear/riscv64/debug" {
+		t.Fatalf("Unexpected default debug repo %s", layout.DebugRepoURL(10))
+	}
+	layout.DebugRepo = ""
+	if layout.DebugRepoURL(10) != layout.BinaryRepoURL(10) {
+		t.Fatalf("Debuginfo should fall back to the binary repo, not %s",
+			layout.DebugRepoURL(10))
+	}
+	layout.DebugRepo = "{url}/debuginfo/{version}/{arch}"
+	if layout.DebugRepoURL(10) != cdn.URL+"/debuginfo/10/riscv64" {
+		t.Fatalf("Unexpected debug repo %s", layout.DebugRepoURL(10))
+	}
+}
+
+func TestFormatNevra(t *testing.T) {
+	for expected, nevra := range map[string]rpmutils.NEVRA{
+		"bash-4.4-50.x86_64": {Name: "bash", Epoch: "0", Version: "4.4",
+			Release: "50", Arch: "x86_64"},
+		"perl-Git-1:2.20-3.noarch": {Name: "perl-Git", Epoch: "1",
+			Version: "2.20", Release: "3", Arch: "noarch"},
+	} {
+		if repolib.FormatNevra(nevra) != expected {
+			t.Fatalf("Expected %s, got %s", expected, repolib.FormatNevra(nevra))
+		}
+	}
+}
+
+func TestLoadMix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dissector-mix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/mix.json"
+
+	for _, config := range []string{
+		`{"repos": [{"name": "local"}]}`,
+		`{"url": "http://mix"}`,
+		`{"url": "http://mix", "repos": [{"name": "a"}, {"name": "a"}]}`,
+		`{"url": "http://mix", "repos": [{"name": "../a"}]}`,
+		`{"url": "http://mix", "repos": [{"name": "a", "layout": {"update": ""}}]}`,
+	} {
+		err = ioutil.WriteFile(path, []byte(config), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repolib.LoadMix(path, ""); err == nil {
+			t.Fatalf("Expected %s to be rejected", config)
+		}
+	}
+
+	err = ioutil.WriteFile(path, []byte(`{
+    "name": "Example OS",
+    "url": "http://mix",
+    "repos": [
+        {"name": "local", "layout": {"binary_repo": "{url}/repo/{arch}"}},
+        {"name": "clear", "url": "http://cdn", "version": 30000}
+    ]
+}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mix, err := repolib.LoadMix(path, "aarch64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mix.Name != "Example OS" || mix.Layout.UpdateURL(10) != "http://mix/update/10" {
+		t.Fatalf("Unexpected mix %s with layout %+v", mix.Name, mix.Layout)
+	}
+
+	var layers []string
+	for _, l := range mix.Layers(10) {
+		layers = append(layers, fmt.Sprintf("%s %d %s %s", l.Name, l.Version,
+			l.Dir, l.Layout.BinaryRepoURL(l.Version)))
+	}
+	expected := []string{
+		"local 10 10/repos/local http://mix/repo/aarch64",
+		"clear 30000 10/repos/clear http://cdn/releases/30000/clear/aarch64/os",
+	}
+	if strings.Join(layers, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected layers %q", layers)
+	}
 }
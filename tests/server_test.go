@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/intel/clear-linux-dissector/internal/common"
+	"github.com/intel/clear-linux-dissector/internal/fakecdn"
+	"github.com/intel/clear-linux-dissector/internal/repolib"
+	"github.com/intel/clear-linux-dissector/internal/server"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func getJSON(t *testing.T, url string, status int, v interface{}) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Fatalf("Expected status %d for %s, got %s", status, url, resp.Status)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		t.Fatalf("%s: %s", url, err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	updated := fakecdn.Sample(testVersion + 10)
+	for i, p := range updated.Packages {
+		if p.Source == "zlib" {
+			updated.Packages[i].Release = "31"
+		}
+	}
+	for i, s := range updated.Sources {
+		if s.Name == "zlib" {
+			updated.Sources[i].Release = "31"
+		}
+	}
+	cdn, cleanup := startCDN(t, fakecdn.Sample(testVersion), updated)
+	defer cleanup()
+
+	layout := common.DefaultLayout(cdn.URL)
+	versions := []int{testVersion + 10, testVersion}
+	for _, v := range versions {
+		err := repolib.DownloadRepo(v, layout, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repolib.DownloadBinaryDatabases(v, layout, nil, "filelists")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repolib.DownloadBundles(v, layout, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var requests logBuffer
+	s := server.New(versions, layout, nil)
+	s.Log = log.New(&requests, "", 0)
+	if fmt.Sprint(versions) != "[30010 30000]" {
+		t.Fatalf("The versions of the caller were reordered: %v", versions)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	var health struct {
+		Status   string
+		Versions []int
+	}
+	getJSON(t, ts.URL+"/health", http.StatusOK, &health)
+	if health.Status != "ok" || fmt.Sprint(health.Versions) != "[30000 30010]" {
+		t.Fatalf("Unexpected health %+v", health)
+	}
+
+	var bundles []string
+	getJSON(t, ts.URL+"/v1/30000/bundles", http.StatusOK, &bundles)
+	if fmt.Sprint(bundles) != "[kernel-kvm os-core os-core-update]" {
+		t.Fatalf("Unexpected bundles %v", bundles)
+	}
+
+	var bundle struct {
+		Name        string
+		AllPackages map[string]bool
+	}
+	getJSON(t, ts.URL+"/v1/latest/bundles/os-core-update", http.StatusOK,
+		&bundle)
+	if bundle.Name != "os-core-update" || !bundle.AllPackages["zlib-lib"] {
+		t.Fatalf("Unexpected bundle %+v", bundle)
+	}
+
+	var packages map[string]string
+	getJSON(t, ts.URL+"/v1/30010/packages", http.StatusOK, &packages)
+	if packages["zlib-lib"] != "zlib-1.2.11-31.src.rpm" {
+		t.Fatalf("Unexpected srpm mapping %v", packages)
+	}
+
+	var info repolib.PackageInfo
+	getJSON(t, ts.URL+"/v1/30000/packages/bash", http.StatusOK, &info)
+	if info.SourceRpm != "bash-4.4-50.src.rpm" || info.License != "GPL-3.0" {
+		t.Fatalf("Unexpected package %+v", info)
+	}
+
+	var owners struct {
+		Packages []string
+		Srpms    []string
+	}
+	getJSON(t, ts.URL+"/v1/30000/files?path=/usr/lib64/libc.so.6",
+		http.StatusOK, &owners)
+	if fmt.Sprint(owners.Packages) != "[libc6]" ||
+		fmt.Sprint(owners.Srpms) != "[glibc-2.27-187.src.rpm]" {
+		t.Fatalf("Unexpected owners %+v", owners)
+	}
+
+	var licenses map[string]string
+	getJSON(t, ts.URL+"/v1/30000/licenses", http.StatusOK, &licenses)
+	if licenses["zlib-1.2.11-30.src.rpm"] != "Zlib" {
+		t.Fatalf("Unexpected licenses %v", licenses)
+	}
+
+	var diff repolib.PackageDiff
+	getJSON(t, ts.URL+"/v1/diff?from=30000&to=30010", http.StatusOK, &diff)
+	if len(diff.Changed) != 1 || len(diff.Added) != 0 || len(diff.Removed) != 0 ||
+		diff.Changed["zlib-lib"].To != "zlib-1.2.11-31.src.rpm" {
+		t.Fatalf("Unexpected diff %+v", diff)
+	}
+
+	var failure struct {
+		Error string
+	}
+	for url, status := range map[string]int{
+		"/v1/29990/bundles":              http.StatusNotFound,
+		"/v1/current/bundles":            http.StatusBadRequest,
+		"/v1/30000/bundles/os-cor":       http.StatusNotFound,
+		"/v1/30000/packages/bsah":        http.StatusNotFound,
+		"/v1/30000/files?path=usr/bin/x": http.StatusBadRequest,
+		"/v1/30000/files?path=/missing":  http.StatusNotFound,
+		"/v1/diff?from=30000":            http.StatusBadRequest,
+		"/v2/30000/bundles":              http.StatusNotFound,
+	} {
+		failure.Error = ""
+		getJSON(t, ts.URL+url, status, &failure)
+		if failure.Error == "" {
+			t.Fatalf("Expected an error for %s", url)
+		}
+	}
+	getJSON(t, ts.URL+"/v1/30000/packages/bsah", http.StatusNotFound, &failure)
+	if failure.Error != "No package bsah in version 30000. Did you mean bash?" {
+		t.Fatalf("Expected a suggestion: %s", failure.Error)
+	}
+
+	if !requests.waitFor("GET /v1/30000/files?path=/usr/lib64/libc.so.6 200 ") ||
+		!requests.waitFor("GET /v1/29990/bundles 404 ") {
+		t.Fatalf("Requests were not logged: %s", requests.String())
+	}
+}
+
+func TestServerCommand(t *testing.T) {
+	cdn, cleanup := startCDN(t)
+	defer cleanup()
+
+	cmd := exec.Command(filepath.Join(binDir, "server"), "-clear_version",
+		"latest", "-repo_url", cdn.URL, "-addr", "127.0.0.1:0")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	// The listening address is printed once the versions are cached
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := strings.Index(line, "http://")
+	if !strings.HasPrefix(line, "Serving [30000] on ") || i < 0 {
+		t.Fatalf("Unexpected output %q", line)
+	}
+
+	var health struct {
+		Status string
+	}
+	getJSON(t, strings.TrimSpace(line[i:])+"/health", http.StatusOK, &health)
+	if health.Status != "ok" {
+		t.Fatalf("Unexpected health %+v", health)
+	}
+}